@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ====== session affinity ======
+// 请求带 AffinityKey 时，center 记住该 key 上次分到的实例（按 service 区分）。
+// 下次若该实例仍有可用 slot，且 delay 不比最优候选差超过容忍度，就优先选它（保住 KV cache / 上下文）。
+// 条目随分配、续租刷新，闲置超过 affinityTTL 由 reaper 清理；随 store 快照持久化。
+
+const (
+	defaultAffinityTolerance = 50 * time.Millisecond
+	defaultAffinityTTL       = 30 * time.Minute
+)
+
+type AffinityEntry struct {
+	ServiceID  string    `json:"serviceId"`
+	SiteName   string    `json:"siteName"`
+	InstanceID string    `json:"instanceId"`
+	LastUsed   time.Time `json:"lastUsed"`
+}
+
+func affinityKey(serviceID, key string) string { return serviceID + "/" + key }
+
+// affinityTolerance：service 配置优先（ms），否则默认
+func affinityTolerance(svc Service) int {
+	if svc.AffinityToleranceMs > 0 {
+		return svc.AffinityToleranceMs
+	}
+	return int(defaultAffinityTolerance.Milliseconds())
+}
+
+// applyAffinityLocked 在已排序的候选上应用亲和：命中则把该实例挪到最前，返回说明文字
+func (s *Store) applyAffinityLocked(plan *allocPlan) string {
+	if plan.req.AffinityKey == "" {
+		return ""
+	}
+	e, ok := s.affinity[affinityKey(plan.req.ServiceID, plan.req.AffinityKey)]
+	if !ok || time.Since(e.LastUsed) > s.affinityTTL {
+		return "miss: no affinity entry"
+	}
+
+	idx := -1
+	bestDelay := -1
+	for i, c := range plan.cands {
+		if c.m.InstanceID == e.InstanceID {
+			idx = i
+		}
+		if bestDelay < 0 || c.m.DelayMs < bestDelay {
+			bestDelay = c.m.DelayMs
+		}
+	}
+	if idx < 0 {
+		return fmt.Sprintf("miss: %s unavailable", e.InstanceID)
+	}
+	tol := affinityTolerance(plan.svc)
+	if d := plan.cands[idx].m.DelayMs; d > bestDelay+tol {
+		return fmt.Sprintf("miss: %s delay %dms > best %dms + %dms", e.InstanceID, d, bestDelay, tol)
+	}
+
+	chosen := plan.cands[idx]
+	copy(plan.cands[1:idx+1], plan.cands[:idx])
+	plan.cands[0] = chosen
+	return "hit: " + e.InstanceID
+}
+
+// touchAffinityLocked 记录/刷新 key -> 实例
+func (s *Store) touchAffinityLocked(serviceID, key, siteName, instanceID string, now time.Time) {
+	if key == "" {
+		return
+	}
+	s.affinity[affinityKey(serviceID, key)] = AffinityEntry{
+		ServiceID:  serviceID,
+		SiteName:   siteName,
+		InstanceID: instanceID,
+		LastUsed:   now,
+	}
+}
+
+// pruneAffinityLocked 清掉闲置过久的亲和条目
+func (s *Store) pruneAffinityLocked(now time.Time) {
+	for k, e := range s.affinity {
+		if now.Sub(e.LastUsed) > s.affinityTTL {
+			delete(s.affinity, k)
+		}
+	}
+}
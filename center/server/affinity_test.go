@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func affinityReq(d1, d2 int) AllocateRequest {
+	return AllocateRequest{ServiceID: "svc", AffinityKey: "sess", Measurements: []Measurement{
+		{SiteName: "s1", InstanceID: "s1-a", DelayMs: d1},
+		{SiteName: "s2", InstanceID: "s2-a", DelayMs: d2},
+	}}
+}
+
+func TestAffinityHitMissAndFallback(t *testing.T) {
+	s := newTestStore(t,
+		Deployment{SiteName: "s1", Gas: 2, Cost: 1, Instances: []Instance{{InstanceID: "s1-a", Capacity: 2}}},
+		Deployment{SiteName: "s2", Gas: 2, Cost: 1, Instances: []Instance{{InstanceID: "s2-a", Capacity: 2}}},
+	)
+	if ex, _ := s.Explain(affinityReq(10, 20)); ex.Affinity != "miss: no affinity entry" {
+		t.Fatalf("affinity before first allocation = %q", ex.Affinity)
+	}
+	if resp, err := s.Allocate(context.Background(), affinityReq(10, 20)); err != nil || resp.InstanceID != "s1-a" {
+		t.Fatalf("first allocation: %+v, %v", resp, err)
+	}
+
+	// 上次的实例 delay 在容忍度（默认 50ms）内：沿用，即使不是最优
+	ex, err := s.Explain(affinityReq(40, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Affinity != "hit: s1-a" || ex.Chosen.InstanceID != "s1-a" {
+		t.Fatalf("within tolerance: affinity %q, chosen %s", ex.Affinity, ex.Chosen.InstanceID)
+	}
+
+	// 超出容忍度：按打分选
+	ex, _ = s.Explain(affinityReq(100, 10))
+	if !strings.HasPrefix(ex.Affinity, "miss: s1-a delay") || ex.Chosen.InstanceID != "s2-a" {
+		t.Fatalf("beyond tolerance: affinity %q, chosen %s", ex.Affinity, ex.Chosen.InstanceID)
+	}
+
+	// 上次的实例满了：回落到其它实例
+	if _, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc", Measurements: []Measurement{{SiteName: "s1", InstanceID: "s1-a"}}}); err != nil {
+		t.Fatal(err)
+	}
+	ex, _ = s.Explain(affinityReq(10, 20))
+	if ex.Affinity != "miss: s1-a unavailable" || ex.Chosen.InstanceID != "s2-a" {
+		t.Fatalf("full instance: affinity %q, chosen %s", ex.Affinity, ex.Chosen.InstanceID)
+	}
+
+	// 闲置超过 TTL 的条目被清理
+	s.ReapExpired(time.Now().Add(s.affinityTTL + time.Minute))
+	if ex, _ := s.Explain(affinityReq(40, 10)); ex.Affinity != "miss: no affinity entry" {
+		t.Fatalf("after TTL: affinity %q", ex.Affinity)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ====== multi-armed bandit strategies ======
+// 每个 (service, instance) 是一条 arm；reward 由调用反馈算出（见 banditReward），
+// 在 Feedback 时更新。两种策略：
+//   - ucb1：均值 + sqrt(2 ln N / n)，没拉过的 arm 优先探索
+//   - thompson：按 Beta(1+Σr, 1+Σ(1-r)) 采样，取样本最大者
+// 状态存在 Store.bandit 里并随快照落盘；planLocked 把当前 arm 统计拷到 scored.arm 供 Rank 使用。
+
+// 端到端延迟到 reward 的参考尺度：latency = banditLatencyRefMs 时成功的 reward 为 0.5
+const banditLatencyRefMs = 1000.0
+
+type BanditArm struct {
+	ServiceID  string    `json:"serviceId"`
+	InstanceID string    `json:"instanceId"`
+	Pulls      int       `json:"pulls"`     // 收到 reward 的次数
+	RewardSum  float64   `json:"rewardSum"` // Σr，r ∈ [0,1]
+	LastAt     time.Time `json:"lastAt"`
+}
+
+func (a BanditArm) mean() float64 {
+	if a.Pulls == 0 {
+		return 0
+	}
+	return a.RewardSum / float64(a.Pulls)
+}
+
+func banditKey(serviceID, instanceID string) string { return serviceID + "/" + instanceID }
+
+// banditReward：失败为 0；成功按端到端延迟折算到 (0,1]，越快越接近 1
+func banditReward(success bool, latencyMs float64) float64 {
+	if !success {
+		return 0
+	}
+	return 1 / (1 + math.Max(latencyMs, 0)/banditLatencyRefMs)
+}
+
+// recordBanditLocked 用一次调用反馈更新 arm；没给 latency 时用该实例平滑后的网络 delay + 计算耗时估算
+func (s *Store) recordBanditLocked(rec AllocationRecord, fb FeedbackRequest, now time.Time) {
+	latency := float64(fb.LatencyMs)
+	if latency <= 0 {
+		d, _ := s.smoothedDelayLocked(rec.InstanceID)
+		latency = float64(d + fb.ComputeMs)
+	}
+	key := banditKey(rec.ServiceID, rec.InstanceID)
+	a := s.bandit[key]
+	if a == nil {
+		a = &BanditArm{ServiceID: rec.ServiceID, InstanceID: rec.InstanceID}
+		s.bandit[key] = a
+	}
+	a.Pulls++
+	a.RewardSum += banditReward(fb.Success != nil && *fb.Success, latency)
+	a.LastAt = now
+}
+
+func (s *Store) banditArmLocked(serviceID, instanceID string) BanditArm {
+	if a := s.bandit[banditKey(serviceID, instanceID)]; a != nil {
+		return *a
+	}
+	return BanditArm{ServiceID: serviceID, InstanceID: instanceID}
+}
+
+// BanditArms 列出某 service 的 arm 统计（serviceID 为空列出全部）
+func (s *Store) BanditArms(serviceID string) []BanditArm {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := []BanditArm{}
+	for key, a := range s.bandit {
+		if serviceID != "" && !strings.HasPrefix(key, serviceID+"/") {
+			continue
+		}
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return banditKey(out[i].ServiceID, out[i].InstanceID) < banditKey(out[j].ServiceID, out[j].InstanceID)
+	})
+	return out
+}
+
+func init() {
+	RegisterScorer("ucb1", ScorerFunc(rankUCB1))
+	RegisterScorer("thompson", ScorerFunc(rankThompson))
+}
+
+// 没拉过的 arm 的分数：比任何拉过的都小，但必须是有限值（分数会进 explain / 历史的 JSON）
+const ucb1UnpulledScore = -math.MaxFloat64
+
+// ucb1：score = -(mean + sqrt(2 ln N / n))；n=0 的 arm 得 ucb1UnpulledScore，先被探索（多个时按 delay）
+func rankUCB1(cands []scored, req AllocateRequest) {
+	total := 0
+	for _, c := range cands {
+		total += c.arm.Pulls
+	}
+	for i, c := range cands {
+		if c.arm.Pulls == 0 {
+			cands[i].score = ucb1UnpulledScore
+			continue
+		}
+		bonus := math.Sqrt(2 * math.Log(float64(max(total, 1))) / float64(c.arm.Pulls))
+		cands[i].score = -(c.arm.mean() + bonus)
+	}
+	sortByScore(cands)
+}
+
+// thompson：score = -θ，θ ~ Beta(1+Σr, 1+Σ(1-r))
+func rankThompson(cands []scored, req AllocateRequest) {
+	for i, c := range cands {
+		alpha := 1 + c.arm.RewardSum
+		beta := 1 + float64(c.arm.Pulls) - c.arm.RewardSum
+		cands[i].score = -betaSample(alpha, beta)
+	}
+	sortByScore(cands)
+}
+
+func betaSample(alpha, beta float64) float64 {
+	x := gammaSample(alpha)
+	y := gammaSample(beta)
+	if x+y == 0 {
+		return 0.5
+	}
+	return x / (x + y)
+}
+
+// gammaSample：Marsaglia-Tsang，shape > 0，scale = 1
+func gammaSample(shape float64) float64 {
+	if shape < 1 {
+		// Gamma(a) = Gamma(a+1) * U^(1/a)
+		return gammaSample(shape+1) * math.Pow(rand.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rand.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rand.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
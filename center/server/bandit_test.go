@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// ucb1 给没拉过的 arm 的分数必须能编码成 JSON：explain 和历史事件里都带分数
+func TestUCB1ScoresEncodeAsJSON(t *testing.T) {
+	s := newTestStore(t,
+		Deployment{SiteName: "s1", Gas: 2, Cost: 1},
+		Deployment{SiteName: "s2", Gas: 2, Cost: 1},
+	)
+	req := AllocateRequest{ServiceID: "svc", Strategy: "ucb1", Measurements: []Measurement{
+		{SiteName: "s1", InstanceID: "s1-a", DelayMs: 10},
+		{SiteName: "s2", InstanceID: "s2-a", DelayMs: 20},
+	}}
+
+	ex, err := s.Explain(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := json.Marshal(ex); err != nil {
+		t.Fatalf("marshal explain: %v", err)
+	}
+	if ex.Chosen == nil || ex.Chosen.SiteName != "s1" {
+		t.Fatalf("chosen %+v, want s1 (unpulled arms tie-break by delay)", ex.Chosen)
+	}
+
+	if _, err := s.Allocate(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	page := s.History(HistoryFilter{Type: EventAllocated}, 0, 0)
+	if page.Total != 1 || page.Events[0].Scoring == nil {
+		t.Fatalf("history %+v, want one allocated event with scoring", page)
+	}
+	if _, err := json.Marshal(page); err != nil {
+		t.Fatalf("marshal history: %v", err)
+	}
+
+	// 重启后从 history.jsonl 读回，说明事件确实落盘了
+	if got := NewStore().History(HistoryFilter{Type: EventAllocated}, 0, 0).Total; got != 1 {
+		t.Fatalf("reloaded %d allocated events, want 1", got)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ====== batch allocation ======
+// 一次申请 Count 个 slot：在 store 锁内一次性选好并扣减，凑不齐则整体失败、不做任何扣减。
+
+const (
+	SpreadPack   = "pack"   // 先把最优实例/站点填满，再往后排
+	SpreadSpread = "spread" // 按站点轮转，尽量分散到不同站点
+)
+
+var ErrInsufficientGas = errors.New("not enough available slots for batch")
+
+func (s *Store) AllocateBatch(ctx context.Context, req AllocateRequest) ([]AllocateResponse, error) {
+	req.arrivedAt = time.Now()
+	v, err := s.allocateOrWait(ctx, req, func() (any, error) {
+		return s.allocateBatchLocked(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]AllocateResponse), nil
+}
+
+func (s *Store) allocateBatchLocked(req AllocateRequest) ([]AllocateResponse, error) {
+	if req.Count < 1 {
+		return nil, fmt.Errorf("%w: Count must be >= 1", ErrBadRequest)
+	}
+	if req.Spread == "" {
+		req.Spread = SpreadPack
+	}
+	if req.Spread != SpreadPack && req.Spread != SpreadSpread {
+		return nil, fmt.Errorf("%w: unknown Spread %q", ErrBadRequest, req.Spread)
+	}
+
+	plan, err := s.planLocked(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.budgetExhaustedLocked(plan); err != nil {
+		return nil, err
+	}
+	if _, err := s.checkQuotaLocked(plan.req, plan.req.Gas*req.Count, req.Count); err != nil {
+		return nil, err
+	}
+	picks := pickBatch(plan.cands, req.Count, plan.req.Gas, req.Spread, plan.allowance)
+	if len(picks) < req.Count {
+		return nil, fmt.Errorf("%w: want %d, only %d available", ErrInsufficientGas, req.Count, len(picks))
+	}
+	amount := 0.0
+	for _, c := range picks {
+		amount += c.price * float64(plan.req.Gas)
+	}
+	if err := s.checkBudgetLocked(plan, amount); err != nil {
+		return nil, err
+	}
+	if err := s.admitLocked(plan.req, plan.req.Gas*req.Count, req.Count); err != nil {
+		return nil, err
+	}
+
+	s.recordPlanDelaysLocked(plan)
+
+	out := make([]AllocateResponse, 0, len(picks))
+	for _, c := range picks {
+		resp, err := s.commitLocked(plan, c)
+		if err != nil {
+			// 不该发生（pickBatch 已按实例余量挑选）；已提交的回滚，保持整体失败的语义
+			for _, done := range out {
+				_ = s.releaseLocked(done.AllocationID, EndCancelled)
+			}
+			return nil, err
+		}
+		out = append(out, resp)
+	}
+	return out, nil
+}
+
+// pickBatch 在已排序的候选里挑 n 份、每份 gas 个 slot（同一实例可以被挑多次，不超过其 available；
+// allowance 非 nil 时每个站点也不超过其额度）。只做选择不扣减；返回数量 < n 表示凑不齐。
+// 余量按实例 ID 计，即使 cands 里同一实例出现多次也不会超分。
+func pickBatch(cands []scored, n, gas int, spread string, allowance map[string]siteAllowance) []scored {
+	left := map[string]int{}
+	for _, c := range cands {
+		if _, ok := left[c.m.InstanceID]; !ok {
+			left[c.m.InstanceID] = c.available
+		}
+	}
+	siteLeft := map[string]int{}
+	for site, a := range allowance {
+		siteLeft[site] = a.slots
+	}
+	fits := func(i int) bool {
+		if left[cands[i].m.InstanceID] < gas {
+			return false
+		}
+		if sl, ok := siteLeft[cands[i].m.SiteName]; ok && sl < gas {
+			return false
+		}
+		return true
+	}
+	take := func(i int) {
+		left[cands[i].m.InstanceID] -= gas
+		if _, ok := siteLeft[cands[i].m.SiteName]; ok {
+			siteLeft[cands[i].m.SiteName] -= gas
+		}
+	}
+	var out []scored
+
+	if spread == SpreadPack {
+		for i, c := range cands {
+			for fits(i) && len(out) < n {
+				take(i)
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+
+	// spread：站点按其最优候选的名次排序，每轮每个站点取一个 slot（站内仍按名次）
+	var sites []string
+	bySite := map[string][]int{}
+	for i, c := range cands {
+		if _, ok := bySite[c.m.SiteName]; !ok {
+			sites = append(sites, c.m.SiteName)
+		}
+		bySite[c.m.SiteName] = append(bySite[c.m.SiteName], i)
+	}
+	for len(out) < n {
+		progressed := false
+		for _, site := range sites {
+			if len(out) >= n {
+				break
+			}
+			for _, i := range bySite[site] {
+				if fits(i) {
+					take(i)
+					out = append(out, cands[i])
+					progressed = true
+					break
+				}
+			}
+		}
+		if !progressed {
+			break
+		}
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// 同一实例的测量重复上报时，batch 不能超过实例容量
+func TestBatchDuplicateMeasurementsDoNotOvercommit(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	m := Measurement{SiteName: "s1", InstanceID: "s1-a", DelayMs: 10}
+	req := AllocateRequest{ServiceID: "svc", Count: 3, Measurements: []Measurement{m, m, m}}
+
+	out, err := s.AllocateBatch(context.Background(), req)
+	if !errors.Is(err, ErrInsufficientGas) {
+		t.Fatalf("want ErrInsufficientGas, got %v (%d allocations)", err, len(out))
+	}
+	if got := s.available(t, "s1", "s1-a"); got != 1 {
+		t.Fatalf("available = %d after failed batch, want 1", got)
+	}
+
+	req.Count = 1
+	if _, err := s.AllocateBatch(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.available(t, "s1", "s1-a"); got != 0 {
+		t.Fatalf("available = %d, want 0", got)
+	}
+}
+
+func TestPickBatchKeysByInstance(t *testing.T) {
+	c := scored{m: Measurement{SiteName: "s1", InstanceID: "s1-a"}, available: 2}
+	if got := pickBatch([]scored{c, c, c}, 3, 1, SpreadPack, nil); len(got) != 2 {
+		t.Fatalf("pack picked %d, want 2", len(got))
+	}
+	if got := pickBatch([]scored{c, c, c}, 3, 1, SpreadSpread, nil); len(got) != 2 {
+		t.Fatalf("spread picked %d, want 2", len(got))
+	}
+}
+
+func TestCommitRefusesOvercommit(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plan, err := s.planLocked(AllocateRequest{ServiceID: "svc"})
+	if err != nil || len(plan.cands) != 1 {
+		t.Fatalf("plan: %v, %d candidates", err, len(plan.cands))
+	}
+	if _, err := s.commitLocked(plan, plan.cands[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.commitLocked(plan, plan.cands[0]); !errors.Is(err, ErrNoGas) {
+		t.Fatalf("second commit: want ErrNoGas, got %v", err)
+	}
+	if got := len(s.allocations); got != 1 {
+		t.Fatalf("%d allocations, want 1", got)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ====== per-client / per-tenant spending budget ======
+// Budget 按主体（client 或 tenant）限制一个周期内的花费（Limit，与 Deployment.Cost 同单位）。
+// 已花费 = 账本里本周期结束的 allocation 金额 + 当前仍持有的 allocation 金额（price × gas）。
+// 主体 ID 为 "*" 的是该类主体的默认预算；具体 ID 的配置优先。
+// 分配时（allocateLocked / batch）先检查剩余预算：已用完直接拒绝；
+// ExcludeOverBudget 时把单价 × Gas 超过剩余预算的候选排除，否则选中的候选超预算才拒绝（ErrBudgetExceeded，HTTP 402）。
+// 这些检查都在抢占（preemptLocked）之前做，抢占也只会为预算内的实例腾 slot；抢占后重新打分选中的候选再查一次。
+
+const (
+	BudgetDay   = "day"
+	BudgetWeek  = "week"
+	BudgetMonth = "month"
+	BudgetTotal = "total" // 不分周期，累计
+)
+
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+type Budget struct {
+	Kind              string  `json:"kind"` // client | tenant
+	ID                string  `json:"id"`   // "*" = 默认
+	Limit             float64 `json:"limit"`
+	Period            string  `json:"period,omitempty"`            // day | week | month（默认）| total，按 PRICING_TZ 时区切分
+	ExcludeOverBudget bool    `json:"excludeOverBudget,omitempty"` // 打分前排除超出剩余预算的候选
+}
+
+type BudgetStatus struct {
+	Budget
+	PeriodStart time.Time `json:"periodStart"` // total 时为零值
+	PeriodEnd   time.Time `json:"periodEnd"`
+	Spent       float64   `json:"spent"`  // 本周期已结算（账本）
+	Active      float64   `json:"active"` // 当前持有的 allocation 金额
+	Remaining   float64   `json:"remaining"`
+}
+
+func (b Budget) validate() error {
+	if b.Kind != QuotaClient && b.Kind != QuotaTenant {
+		return fmt.Errorf("%w: kind must be client or tenant", ErrBadRequest)
+	}
+	if strings.TrimSpace(b.ID) == "" {
+		return fmt.Errorf("%w: missing id", ErrBadRequest)
+	}
+	if b.Limit < 0 || math.IsNaN(b.Limit) || math.IsInf(b.Limit, 0) {
+		return fmt.Errorf("%w: limit must be >= 0", ErrBadRequest)
+	}
+	switch b.Period {
+	case "", BudgetDay, BudgetWeek, BudgetMonth, BudgetTotal:
+	default:
+		return fmt.Errorf("%w: period must be day, week, month or total", ErrBadRequest)
+	}
+	return nil
+}
+
+// budgetPeriod 返回 now 所在周期的 [start, end)；total 返回零值
+func budgetPeriod(period string, now time.Time) (time.Time, time.Time) {
+	t := now.In(pricingTZ)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, pricingTZ)
+	switch period {
+	case BudgetDay:
+		return day, day.AddDate(0, 0, 1)
+	case BudgetWeek:
+		// 周一为一周开始
+		start := day.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case BudgetTotal:
+		return time.Time{}, time.Time{}
+	default:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, pricingTZ)
+		return start, start.AddDate(0, 1, 0)
+	}
+}
+
+func roundMoney(v float64) float64 { return math.Round(v*100) / 100 }
+
+func (s *Store) UpsertBudget(b Budget) error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+	if b.Period == "" {
+		b.Period = BudgetMonth
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.budgets[quotaKey(b.Kind, b.ID)] = b
+	return nil
+}
+
+func (s *Store) DeleteBudget(kind, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.budgets, quotaKey(kind, id))
+}
+
+// ListBudgets 返回全部预算及当前花费（默认预算不统计）
+func (s *Store) ListBudgets() []BudgetStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	out := make([]BudgetStatus, 0, len(s.budgets))
+	for _, b := range s.budgets {
+		if b.ID == "*" {
+			out = append(out, BudgetStatus{Budget: b, Remaining: b.Limit})
+			continue
+		}
+		out = append(out, s.budgetStatusLocked(b, b.Kind, b.ID, now))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return quotaKey(out[i].Kind, out[i].ID) < quotaKey(out[j].Kind, out[j].ID)
+	})
+	return out
+}
+
+// BudgetStatusOf 返回主体当前生效的预算（具体 ID 优先，其次 "*"）及花费
+func (s *Store) BudgetStatusOf(kind, id string) (BudgetStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.effectiveBudgetLocked(kind, id)
+	if !ok {
+		return BudgetStatus{}, false
+	}
+	return s.budgetStatusLocked(b, kind, id, time.Now()), true
+}
+
+func (s *Store) effectiveBudgetLocked(kind, id string) (Budget, bool) {
+	if b, ok := s.budgets[quotaKey(kind, id)]; ok {
+		return b, true
+	}
+	if sharedSubject(kind, id) {
+		return Budget{}, false
+	}
+	b, ok := s.budgets[quotaKey(kind, "*")]
+	return b, ok
+}
+
+// budgetStatusLocked 统计主体 (kind, id) 在 b 当前周期的花费；调用方需持有 s.mu
+func (s *Store) budgetStatusLocked(b Budget, kind, id string, now time.Time) BudgetStatus {
+	st := BudgetStatus{Budget: b}
+	st.PeriodStart, st.PeriodEnd = budgetPeriod(b.Period, now)
+
+	match := func(clientID, tenantID string) bool {
+		return (kind == QuotaClient && clientID == id) || (kind == QuotaTenant && tenantID == id)
+	}
+	for _, e := range s.ledger {
+		if !match(e.ClientID, e.TenantID) {
+			continue
+		}
+		if !st.PeriodStart.IsZero() && (e.EndAt.Before(st.PeriodStart) || !e.EndAt.Before(st.PeriodEnd)) {
+			continue
+		}
+		st.Spent += e.Amount
+	}
+	// 还没结束的 allocation 结束时会记到当前周期，先按持有金额占用预算；
+	// 被抢占、还没入账的（见 settleRevokedLocked）同样算上
+	for _, recs := range []map[string]AllocationRecord{s.allocations, s.revoked} {
+		for _, rec := range recs {
+			if match(rec.ClientID, rec.TenantID) {
+				st.Active += rec.Price * float64(rec.gas())
+			}
+		}
+	}
+	st.Spent = roundMoney(st.Spent)
+	st.Active = roundMoney(st.Active)
+	st.Remaining = roundMoney(math.Max(0, b.Limit-st.Spent-st.Active))
+	return st
+}
+
+// budgetLimit 是一次分配请求适用的最紧预算
+type budgetLimit struct {
+	remaining float64
+	exclude   bool
+	subject   string // 给错误信息用，如 "client alice"
+}
+
+// budgetLimitLocked 取请求的 client / tenant 预算中剩余最少的；都没有配置时返回 nil
+func (s *Store) budgetLimitLocked(req AllocateRequest, now time.Time) *budgetLimit {
+	type subject struct{ kind, id string }
+	subjects := []subject{{QuotaClient, req.ClientID}}
+	if req.TenantID != "" {
+		subjects = append(subjects, subject{QuotaTenant, req.TenantID})
+	}
+
+	var out *budgetLimit
+	for _, sub := range subjects {
+		b, ok := s.effectiveBudgetLocked(sub.kind, sub.id)
+		if !ok {
+			continue
+		}
+		st := s.budgetStatusLocked(b, sub.kind, sub.id, now)
+		if out == nil || st.Remaining < out.remaining {
+			exclude := b.ExcludeOverBudget || (out != nil && out.exclude)
+			out = &budgetLimit{remaining: st.Remaining, exclude: exclude, subject: sub.kind + " " + sub.id}
+			continue
+		}
+		out.exclude = out.exclude || b.ExcludeOverBudget
+	}
+	return out
+}
+
+// checkBudgetLocked 在选定候选后检查本次金额（price × gas 之和）是否超出剩余预算
+func (s *Store) checkBudgetLocked(plan *allocPlan, amount float64) error {
+	b := plan.budget
+	if b == nil {
+		return nil
+	}
+	if amount = roundMoney(amount); amount > b.remaining {
+		return fmt.Errorf("%w: %s needs %.2f, remaining %.2f", ErrBudgetExceeded, b.subject, amount, b.remaining)
+	}
+	return nil
+}
+
+// budgetExhaustedLocked 在挑选候选之前调用：预算已用完，或候选全部因预算被排除时拒绝
+func (s *Store) budgetExhaustedLocked(plan *allocPlan) error {
+	b := plan.budget
+	if b == nil {
+		return nil
+	}
+	if b.remaining <= 0 {
+		return fmt.Errorf("%w: %s has no remaining budget", ErrBudgetExceeded, b.subject)
+	}
+	if len(plan.cands) == 0 && plan.overBudget > 0 {
+		return fmt.Errorf("%w: %s remaining %.2f, %d candidate(s) over budget",
+			ErrBudgetExceeded, b.subject, b.remaining, plan.overBudget)
+	}
+	return nil
+}
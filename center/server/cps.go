@@ -1,15 +1,54 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math"
 	"sort"
+	"time"
 )
 
 type scored struct {
 	m     Measurement
-	cost  int
+	cost  int     // 部署基础价
+	price float64 // 生效价格（动态定价后），打分的 cost 维度用它
 	cscid string
 	score float64
+
+	available int     // 实例剩余 slot
+	capacity  int     // 实例总 slot
+	computeMs float64 // 预计计算耗时（ms），供 computeTime 权重使用
+	util      float64 // 部署占用率 0~1，供 load 权重使用
+
+	reliability float64   // 调用反馈成功率 EWMA，供 reliability 权重使用
+	arm         BanditArm // bandit 策略用的 arm 统计（拷贝）
+
+	measuredMs int // client 本次实测 delay；m.DelayMs 为按 DelayStat 换算、再与 serverMs 混合后的打分值
+	serverMs   int // center 探测 RTT（EWMA），-1 = 没有可用探测
+	health     string
+
+	st   *DeploymentState // 所属部署
+	norm Weights          // 各维度 min-max 归一化后的值（复用 Weights 的字段形状）
+}
+
+// effectiveComputingTime：站点覆盖优先，其次 service 声明；都解析不出返回 false
+func effectiveComputingTime(svc Service, dep Deployment) (string, time.Duration, bool) {
+	if dep.ComputingTime != "" {
+		if d, ok := parseComputingTime(dep.ComputingTime); ok {
+			return dep.ComputingTime, d, true
+		}
+	}
+	d, ok := parseComputingTime(svc.ComputingTime)
+	return svc.ComputingTime, d, ok
+}
+
+// utilization = 1 - GasAvailable/Gas
+func (st *DeploymentState) utilization() float64 {
+	if st.Deployment.Gas <= 0 {
+		return 0
+	}
+	return 1 - float64(st.GasAvailable)/float64(st.Deployment.Gas)
 }
 
 // 权重规则：
@@ -26,38 +65,140 @@ func weights(costPref, delayPref string) (wCost, wDelay float64) {
 	return 0.5, 0.5
 }
 
+// resolveWeights 决定本次请求的权重，优先级：
+//  1. 请求显式给的 Weights
+//  2. 请求给了 CostPref/DelayPref 字符串简写：按 weights() 的 cost / delay 两维换算（向后兼容，不含计算耗时和负载）
+//  3. service 的 DefaultWeights（运营方为该 service 打开计算耗时 / 负载等维度）
+//  4. 都没有：简写的默认 0.5 / 0.5
+func resolveWeights(req AllocateRequest, svc Service) (Weights, error) {
+	if req.Weights != nil {
+		return normalizeWeights(*req.Weights)
+	}
+	if req.CostPref == "" && req.DelayPref == "" && svc.DefaultWeights != nil {
+		return normalizeWeights(*svc.DefaultWeights)
+	}
+	wCost, wDelay := weights(req.CostPref, req.DelayPref)
+	return Weights{Cost: wCost, Delay: wDelay}, nil
+}
+
+// normalizeWeights 校验各维度为有限非负数，并归一化到和为 1
+func normalizeWeights(w Weights) (Weights, error) {
+	names := []string{"cost", "delay", "computeTime", "load", "reliability"}
+	for i, v := range []float64{w.Cost, w.Delay, w.ComputeTime, w.Load, w.Reliability} {
+		if math.IsNaN(v) || math.IsInf(v, 0) || v < 0 {
+			return Weights{}, fmt.Errorf("%w: weight %s must be a finite number >= 0", ErrBadRequest, names[i])
+		}
+	}
+	sum := w.Cost + w.Delay + w.ComputeTime + w.Load + w.Reliability
+	if sum <= 0 {
+		return Weights{}, fmt.Errorf("%w: weights must not all be 0", ErrBadRequest)
+	}
+	return Weights{
+		Cost:        w.Cost / sum,
+		Delay:       w.Delay / sum,
+		ComputeTime: w.ComputeTime / sum,
+		Load:        w.Load / sum,
+		Reliability: w.Reliability / sum,
+	}, nil
+}
+
+// reqWeights 给 Scorer 用：Allocate 已把归一化后的权重写回 req.Weights
+func reqWeights(req AllocateRequest) Weights {
+	if req.Weights != nil {
+		return *req.Weights
+	}
+	w, _ := resolveWeights(req, Service{})
+	return w
+}
+
 func (s *Store) Candidates(serviceID string) []Candidate {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	now := time.Now()
 	var out []Candidate
 	for siteName, bySvc := range s.deployments {
 		st, ok := bySvc[serviceID]
 		if !ok {
 			continue
 		}
+		comp, _, _ := effectiveComputingTime(s.services[serviceID], st.Deployment)
 		out = append(out, Candidate{
-			SiteName:  siteName,
-			ServiceID: serviceID,
-			Gas:       st.Deployment.Gas,
-			Cost:      st.Deployment.Cost,
-			CSCI_ID:   st.Deployment.CSCI_ID,
-			Instances: st.Deployment.Instances,
+			SiteName:      siteName,
+			ServiceID:     serviceID,
+			Gas:           st.Deployment.Gas,
+			Cost:          st.Deployment.Cost,
+			CSCI_ID:       st.Deployment.CSCI_ID,
+			Instances:     s.withHealthLocked(st.Deployment.Instances, now),
+			ComputingTime: comp,
+			Price:         st.priceOf(now),
 		})
 	}
 	return out
 }
 
-func (s *Store) Allocate(req AllocateRequest) (AllocateResponse, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// allocPlan 是一次分配的打分结果：候选已按策略排好序，excluded 记录被排除的实例及原因
+type allocPlan struct {
+	req      AllocateRequest // Weights 已归一化写回
+	svc      Service
+	strategy string
+	weights  Weights
+	cands    []scored
+	excluded []ExcludedCandidate
+	affinity string // 会话亲和结果说明（hit / miss 原因），无 AffinityKey 时为空
+
+	allowance map[string]siteAllowance // 放置约束下各站点额度；nil = 不限
+
+	budget     *budgetLimit // client / tenant 剩余预算；nil = 不限
+	overBudget int          // 因超出预算被排除的候选数
+
+	gasShort []ExcludedCandidate // 只因 slot 不够被排除的实例（其余检查都通过、容量够、价格在预算内）：抢占的候选
 
+	delayStat string // 打分用的 delay 统计量
+	synthetic bool   // measurements 为兜底生成（delay=0），不记入历史
+}
+
+// planLocked 跑完整的筛选 + 打分流程，但不扣 Gas；调用方需持有 s.mu
+func (s *Store) planLocked(req AllocateRequest) (*allocPlan, error) {
 	if req.ServiceID == "" {
-		return AllocateResponse{}, errors.New("missing ServiceID")
+		return nil, fmt.Errorf("%w: missing ServiceID", ErrBadRequest)
+	}
+	if req.Gas < 0 {
+		return nil, fmt.Errorf("%w: Gas must be >= 1", ErrBadRequest)
+	}
+	if req.Gas == 0 {
+		req.Gas = 1
+	}
+	if req.Priority == "" {
+		req.Priority = PriorityStandard
+	}
+	if req.ClientID == "" {
+		// 放置约束按 client 已持有的 allocation 计算，匿名调用方共用 anonymous 会互相占掉额度
+		if c := req.Constraints; c != nil && (c.AvoidHeldSites || c.MaxPerSite > 0) {
+			return nil, fmt.Errorf("%w: ClientID required for avoidHeldSites / maxPerSite", ErrBadRequest)
+		}
+		req.ClientID = anonymousClient
+	}
+	if _, err := priorityRank(req.Priority); err != nil {
+		return nil, err
 	}
+	if req.NotifyURL != "" {
+		if err := validateNotifyURL(req.NotifyURL); err != nil {
+			return nil, err
+		}
+	}
+
+	// 权重：显式数值 > 字符串简写 > service 默认
+	svc := s.services[req.ServiceID]
+	w, err := resolveWeights(req, svc)
+	if err != nil {
+		return nil, err
+	}
+	req.Weights = &w
 
 	// 兜底：如果前端没传 measurements，就用该 service 的所有 instances 生成测量（delay=0）
-	if len(req.Measurements) == 0 {
+	synthetic := len(req.Measurements) == 0
+	if synthetic {
 		for siteName, bySvc := range s.deployments {
 			st, ok := bySvc[req.ServiceID]
 			if !ok {
@@ -74,8 +215,22 @@ func (s *Store) Allocate(req AllocateRequest) (AllocateResponse, error) {
 		}
 	}
 
-	// 计算动态权重
-	wCost, wDelay := weights(req.CostPref, req.DelayPref)
+	// 选择打分策略：请求 > service 默认 > weighted
+	strategy := resolveStrategy(req, svc)
+	scorer, err := lookupScorer(strategy)
+	if err != nil {
+		return nil, err
+	}
+	delayStat := req.DelayStat
+	if delayStat == "" {
+		delayStat = svc.DelayStat
+	}
+	if err := validDelayStat(delayStat); err != nil {
+		return nil, err
+	}
+	if delayStat == "" {
+		delayStat = DelayStatMeasured
+	}
 
 	// 建索引：instanceId -> (siteName, addr, cost, cscid, state)
 	type instInfo struct {
@@ -104,24 +259,50 @@ func (s *Store) Allocate(req AllocateRequest) (AllocateResponse, error) {
 		}
 	}
 
-	var cands []scored
-	var costs []float64
-	var delays []float64
+	plan := &allocPlan{req: req, svc: svc, strategy: strategy, weights: w, delayStat: delayStat, synthetic: synthetic}
+	exclude := func(m Measurement, reason string) {
+		plan.excluded = append(plan.excluded, ExcludedCandidate{
+			SiteName:   m.SiteName,
+			InstanceID: m.InstanceID,
+			DelayMs:    m.DelayMs,
+			Reason:     reason,
+		})
+	}
+	measured := map[string]bool{}
+	now := time.Now()
+	verifyAt := now
+	if !req.arrivedAt.IsZero() {
+		verifyAt = req.arrivedAt
+	}
+
+	// 放置约束：按 client 已持有的站点计算各站点额度
+	allowance := s.siteAllowanceLocked(req)
+	plan.allowance = allowance
+	plan.budget = s.budgetLimitLocked(req, now)
+
+	// best-effort（batch）请求不能动用 service 的保底容量
+	reserved := req.Priority == PriorityBatch && !s.bestEffortFitsLocked(svc, req.Gas*max(req.Count, 1))
 
 	for _, m := range req.Measurements {
+		// 同一实例只按第一条测量算一个候选，否则重复的候选会各自占用实例的 available（batch 超分）
+		if measured[m.InstanceID] {
+			exclude(m, "duplicate measurement")
+			continue
+		}
+		measured[m.InstanceID] = true
+
 		// 兼容前端 SiteName 传错/传空：优先用 instanceId 反查归属
 		info, ok := instIndex[m.InstanceID]
 		if !ok {
 			// 如果 instanceId 找不到，再尝试用 m.SiteName 去 deployments 找（兼容老行为）
 			bySvc, ok2 := s.deployments[m.SiteName]
 			if !ok2 {
+				exclude(m, "unknown instance")
 				continue
 			}
 			st2, ok2 := bySvc[req.ServiceID]
 			if !ok2 {
-				continue
-			}
-			if st2.GasAvailable <= 0 {
+				exclude(m, "service not deployed on site")
 				continue
 			}
 			// 从该 deployment 的实例列表里找 addr
@@ -133,6 +314,7 @@ func (s *Store) Allocate(req AllocateRequest) (AllocateResponse, error) {
 				}
 			}
 			if addr == "" {
+				exclude(m, "unknown instance")
 				continue
 			}
 			info = instInfo{
@@ -144,71 +326,234 @@ func (s *Store) Allocate(req AllocateRequest) (AllocateResponse, error) {
 			}
 		}
 
-		// 没 gas 直接跳过
-		if info.st.GasAvailable <= 0 {
-			continue
-		}
-
-		// 记录最后一次 delay，用于 c-ps view
-		s.lastDelay[m.InstanceID] = m.DelayMs
-
 		// 补齐/纠正 measurement 字段（防止前端传错）
 		m.SiteName = info.siteName
 		if m.Addr == "" {
 			m.Addr = info.addr
 		}
 
-		cands = append(cands, scored{
-			m:     m,
-			cost:  info.cost,
-			cscid: info.cscid,
+		// 该实例剩余 slot 不够本次 Gas 需求直接跳过（按实例计数，不看部署总量）
+		inst := info.st.instance(m.InstanceID)
+		if inst == nil {
+			exclude(m, "unknown instance")
+			continue
+		}
+		// client 上报的 delay 需带站点签名 token（开启 PING_SECRET 时）；兜底生成的不校验
+		if !synthetic {
+			if err := s.verifyMeasurementLocked(m, verifyAt); err != nil {
+				exclude(m, err.Error())
+				continue
+			}
+		}
+		if ok, reason := s.allocatableLocked(m.InstanceID, now); !ok {
+			exclude(m, reason)
+			continue
+		}
+		// slot 不够的实例放到其余检查之后再排除：只有其余条件都满足的才值得抢占
+		short := ""
+		if inst.Available <= 0 {
+			short = "no gas"
+		} else if inst.Available < req.Gas {
+			short = fmt.Sprintf("not enough gas (need %d, have %d)", req.Gas, inst.Available)
+		}
+		if reserved {
+			exclude(m, "guaranteed capacity reserved")
+			continue
+		}
+		if a, ok := allowance[m.SiteName]; ok && a.slots < req.Gas {
+			exclude(m, a.reason)
+			continue
+		}
+		price := effectivePrice(info.st.Deployment, info.st.utilization(), req.Gas*max(req.Count, 1), now)
+		overBudget := plan.budget != nil && roundMoney(price*float64(req.Gas)) > plan.budget.remaining
+		if short != "" {
+			exclude(m, short)
+			if !overBudget && inst.Capacity >= req.Gas {
+				plan.gasShort = append(plan.gasShort, plan.excluded[len(plan.excluded)-1])
+			}
+			continue
+		}
+		if overBudget && plan.budget.exclude {
+			plan.overBudget++
+			exclude(m, fmt.Sprintf("over budget (cost %.2f > remaining %.2f)", price*float64(req.Gas), plan.budget.remaining))
+			continue
+		}
+
+		// 计算耗时：反馈学到的优先；否则用声明值，解析不出的先记为未知（-1），打分前按已知的最慢值补
+		_, comp, compOK := effectiveComputingTime(svc, info.st.Deployment)
+		computeMs, learned := s.learnedComputeLocked(m.InstanceID)
+		if !learned {
+			computeMs = -1
+			if compOK {
+				computeMs = float64(comp.Milliseconds())
+			}
+		}
+
+		// 打分用的 delay：client 侧按统计量取（默认就是本次测量值），再与 center 探测 RTT 混合
+		measuredMs := m.DelayMs
+		m.DelayMs = s.effectiveDelayLocked(m.InstanceID, measuredMs, delayStat)
+		serverMs, probed := s.serverDelayLocked(m.InstanceID)
+		if probed {
+			m.DelayMs = blendDelay(m.DelayMs, serverMs, synthetic, svc)
+		} else {
+			serverMs = -1
+		}
+
+		plan.cands = append(plan.cands, scored{
+			m:           m,
+			measuredMs:  measuredMs,
+			serverMs:    serverMs,
+			health:      s.healthOfLocked(m.InstanceID, now).State,
+			cost:        info.cost,
+			price:       price,
+			cscid:       info.cscid,
+			st:          info.st,
+			available:   inst.Available,
+			capacity:    inst.Capacity,
+			computeMs:   computeMs,
+			util:        info.st.utilization(),
+			reliability: s.reliabilityLocked(m.InstanceID),
+			arm:         s.banditArmLocked(req.ServiceID, m.InstanceID),
 		})
-		costs = append(costs, float64(info.cost))
-		delays = append(delays, float64(m.DelayMs))
 	}
 
-	if len(cands) == 0 {
-		return AllocateResponse{}, errors.New("no available candidates (Gas exhausted or no deployment)")
+	// 部署了但 client 没测到的实例，也列出来便于解释
+	var unmeasured []string
+	for id := range instIndex {
+		if !measured[id] {
+			unmeasured = append(unmeasured, id)
+		}
+	}
+	sort.Strings(unmeasured)
+	for _, id := range unmeasured {
+		info := instIndex[id]
+		exclude(Measurement{SiteName: info.siteName, InstanceID: id, Addr: info.addr}, "not measured")
 	}
 
-	nCost := minMaxNorm(costs)
-	nDelay := minMaxNorm(delays)
+	fillUnknownCompute(plan.cands)
+	fillNorms(plan.cands)
+	scorer.Rank(plan.cands, req)
+	plan.affinity = s.applyAffinityLocked(plan)
+	return plan, nil
+}
 
-	for i := range cands {
-		cands[i].score = wCost*nCost[i] + wDelay*nDelay[i]
+// Allocate 在单个实例上分配 req.Gas 个 slot（默认 1）；req.WaitTimeoutMs > 0 时容量不足会排队等待（见 queue.go）
+func (s *Store) Allocate(ctx context.Context, req AllocateRequest) (AllocateResponse, error) {
+	req.arrivedAt = time.Now()
+	v, err := s.allocateOrWait(ctx, req, func() (any, error) {
+		return s.allocateLocked(req)
+	})
+	if err != nil {
+		return AllocateResponse{}, err
 	}
+	return v.(AllocateResponse), nil
+}
 
-	sort.Slice(cands, func(i, j int) bool {
-		if cands[i].score == cands[j].score {
-			return cands[i].m.DelayMs < cands[j].m.DelayMs
+func (s *Store) allocateLocked(req AllocateRequest) (AllocateResponse, error) {
+	plan, err := s.planLocked(req)
+	if err != nil {
+		return AllocateResponse{}, err
+	}
+	if err := s.budgetExhaustedLocked(plan); err != nil {
+		return AllocateResponse{}, err
+	}
+	// 配额在抢占之前检查（令牌到 commit 前再扣）
+	if _, err := s.checkQuotaLocked(plan.req, plan.req.Gas, 1); err != nil {
+		return AllocateResponse{}, err
+	}
+	preempted := false
+	if len(plan.cands) == 0 && s.preemptLocked(plan) {
+		// 抢占腾出了 slot，重新打分；抢占目标已按预算筛过（plan.gasShort）
+		preempted = true
+		if plan, err = s.planLocked(req); err != nil {
+			return AllocateResponse{}, err
 		}
-		return cands[i].score < cands[j].score
-	})
+	}
+	if len(plan.cands) == 0 {
+		if preempted {
+			// 不该发生（preemptLocked 只挑其余检查都已通过的实例）；撤出来的 slot 不能闲着
+			s.serveWaitersLocked(req.ServiceID)
+		}
+		return AllocateResponse{}, ErrNoCandidates
+	}
+	// 预算按最终选中的候选检查：没抢占时就在抢占之前；抢占后重新打分可能选到别的价格，同样要查
+	if err := s.checkBudgetLocked(plan, plan.cands[0].price*float64(plan.req.Gas)); err != nil {
+		if preempted {
+			s.serveWaitersLocked(req.ServiceID)
+		}
+		return AllocateResponse{}, err
+	}
+	if err := s.admitLocked(plan.req, plan.req.Gas, 1); err != nil {
+		return AllocateResponse{}, err
+	}
+
+	// 记录本次实测 delay（历史 / c-ps view）
+	s.recordPlanDelaysLocked(plan)
+
+	return s.commitLocked(plan, plan.cands[0])
+}
 
-	chosen := cands[0]
-	st := s.deployments[chosen.m.SiteName][req.ServiceID]
+// commitLocked 对选中的候选扣减 req.Gas 个 slot 并登记 allocation；调用方需持有 s.mu。
+// 实例剩余不够时返回错误、不做任何改动（planLocked / pickBatch 应已保证够，走到这里说明选择逻辑有 bug）
+func (s *Store) commitLocked(plan *allocPlan, chosen scored) (AllocateResponse, error) {
+	st := chosen.st
+	gas := plan.req.Gas
 
-	// 原子扣减 1 slot
-	st.GasAvailable -= 1
-	if st.GasAvailable < 0 {
-		st.GasAvailable = 0
+	inst := st.instance(chosen.m.InstanceID)
+	if inst == nil {
+		return AllocateResponse{}, fmt.Errorf("%w: %s", ErrNoInstance, chosen.m.InstanceID)
 	}
+	if inst.Available < gas {
+		return AllocateResponse{}, fmt.Errorf("%w: instance %s has %d slot(s), need %d",
+			ErrNoGas, chosen.m.InstanceID, inst.Available, gas)
+	}
+	inst.Available -= gas
+	st.GasAvailable = sumAvailable(st.Deployment.Instances)
 
+	now := time.Now()
 	allocationID := newID("alloc")
-	s.allocations[allocationID] = AllocationRecord{
-		ServiceID:  req.ServiceID,
-		SiteName:   chosen.m.SiteName,
-		InstanceID: chosen.m.InstanceID,
+	rec := AllocationRecord{
+		ServiceID:   plan.req.ServiceID,
+		SiteName:    chosen.m.SiteName,
+		InstanceID:  chosen.m.InstanceID,
+		AllocatedAt: now,
+		ExpiresAt:   now.Add(s.leaseTTL),
+		Gas:         gas,
+		ClientID:    plan.req.ClientID,
+		TenantID:    plan.req.TenantID,
+		Priority:    plan.req.Priority,
+		NotifyURL:   plan.req.NotifyURL,
+		AffinityKey: plan.req.AffinityKey,
+		Cost:        chosen.cost,
+		Price:       chosen.price,
+		Weights:     plan.weights,
+	}
+	if plan.req.reserve {
+		rec.Reserved = true
+		rec.ExpiresAt = now.Add(s.reserveTTL)
 	}
+	s.allocations[allocationID] = rec
+	s.touchAffinityLocked(rec.ServiceID, rec.AffinityKey, rec.SiteName, rec.InstanceID, now)
+	s.noteAllocatedLocked(rec.InstanceID, allocationID)
+
+	event := EventAllocated
+	if rec.Reserved {
+		event = EventReserved
+	}
+	s.recordEventLocked(event, allocationID, rec, plan.scoringFor(rec.InstanceID), now)
 
 	return AllocateResponse{
 		AllocationID: allocationID,
-		ServiceID:    req.ServiceID,
+		ServiceID:    plan.req.ServiceID,
 		InstanceID:   chosen.m.InstanceID,
 		Addr:         chosen.m.Addr, // 期望是 "/site2-a" 或 "/site2-b"
 		CSCI_ID:      chosen.cscid,
 		Cost:         chosen.cost,
 		GasRemaining: st.GasAvailable,
+		ExpiresAt:    rec.ExpiresAt,
+		Weights:      plan.weights,
+		Gas:          gas,
+		Price:        chosen.price,
 	}, nil
 }
 
@@ -216,20 +561,48 @@ func (s *Store) Release(allocationID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	rec, ok := s.allocations[allocationID]
+	// 已被抢占的 allocation slot 早已收回，owner 来 release 只需清掉记录
+	if _, ok := s.revoked[allocationID]; ok {
+		s.settleRevokedLocked(allocationID)
+		return nil
+	}
+	return s.releaseLocked(allocationID, EndReleased)
+}
+
+// releaseLocked 归还 allocation 占用的 slot，reason 记入账本（见 ledger.go）；调用方需持有 s.mu
+func (s *Store) releaseLocked(allocationID, reason string) error {
+	rec, ok := s.dropAllocationLocked(allocationID)
 	if !ok {
 		return errors.New("allocation not found")
 	}
+	s.endAllocationLocked(allocationID, rec, reason, time.Now())
+
+	// 有 slot 还回来了：按队列顺序唤醒等待者
+	s.serveWaitersLocked(rec.ServiceID)
+	return nil
+}
+
+// dropAllocationLocked 删除 allocation 并把 slot 还给实例，但不唤醒等待者
+func (s *Store) dropAllocationLocked(allocationID string) (AllocationRecord, bool) {
+	rec, ok := s.allocations[allocationID]
+	if !ok {
+		return AllocationRecord{}, false
+	}
 
 	if bySvc, ok := s.deployments[rec.SiteName]; ok {
 		if st, ok := bySvc[rec.ServiceID]; ok {
-			st.GasAvailable += 1
-			if st.GasAvailable > st.Deployment.Gas {
-				st.GasAvailable = st.Deployment.Gas
+			// 还给当初被分配的那个实例，扣多少还多少
+			if inst := st.instance(rec.InstanceID); inst != nil {
+				inst.Available += rec.gas()
+				if inst.Available > inst.Capacity {
+					inst.Available = inst.Capacity
+				}
 			}
+			st.GasAvailable = sumAvailable(st.Deployment.Instances)
 		}
 	}
 
 	delete(s.allocations, allocationID)
-	return nil
+	s.noteDroppedLocked(rec.InstanceID, allocationID)
+	return rec, true
 }
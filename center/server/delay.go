@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ====== delay history ======
+// 每个实例保留最近 delayRingSize 次测量（环形），并维护 EWMA；
+// 打分可选用 measured（本次请求带来的值，默认）/ last / ewma / p50 / p95，避免单次抖动左右结果。
+
+const (
+	delayRingSize    = 64
+	defaultEWMAAlpha = 0.3
+
+	DelayStatMeasured = "measured"
+	DelayStatLast     = "last"
+	DelayStatEWMA     = "ewma"
+	DelayStatP50      = "p50"
+	DelayStatP95      = "p95"
+)
+
+type DelaySample struct {
+	At      time.Time `json:"at"`
+	DelayMs int       `json:"delayMs"`
+}
+
+type DelayHistory struct {
+	Samples []DelaySample `json:"samples"` // 环形缓冲，Next 指向下一个写入位置
+	Next    int           `json:"next"`
+	EWMA    float64       `json:"ewma"`
+	Count   int           `json:"count"` // 累计测量次数（不受环大小限制）
+}
+
+type DelayStats struct {
+	Count    int     `json:"count"`
+	LastMs   int     `json:"lastMs"`
+	EWMAMs   float64 `json:"ewmaMs"`
+	P50Ms    float64 `json:"p50Ms"`
+	P95Ms    float64 `json:"p95Ms"`
+	JitterMs float64 `json:"jitterMs"` // 相邻两次测量差值绝对值的均值
+}
+
+func ewmaAlpha() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("DELAY_EWMA_ALPHA"), 64); err == nil && v > 0 && v <= 1 {
+		return v
+	}
+	return defaultEWMAAlpha
+}
+
+var delayAlpha = ewmaAlpha()
+
+func validDelayStat(stat string) error {
+	switch stat {
+	case "", DelayStatMeasured, DelayStatLast, DelayStatEWMA, DelayStatP50, DelayStatP95:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown DelayStat %q", ErrBadRequest, stat)
+}
+
+func (h *DelayHistory) add(sample DelaySample) {
+	if h.Count == 0 {
+		h.EWMA = float64(sample.DelayMs)
+	} else {
+		h.EWMA = delayAlpha*float64(sample.DelayMs) + (1-delayAlpha)*h.EWMA
+	}
+	h.Count++
+	if len(h.Samples) < delayRingSize {
+		h.Samples = append(h.Samples, sample)
+		h.Next = len(h.Samples) % delayRingSize
+		return
+	}
+	h.Samples[h.Next] = sample
+	h.Next = (h.Next + 1) % delayRingSize
+}
+
+// ordered 按时间先后返回样本
+func (h *DelayHistory) ordered() []DelaySample {
+	if len(h.Samples) < delayRingSize {
+		return append([]DelaySample(nil), h.Samples...)
+	}
+	out := make([]DelaySample, 0, len(h.Samples))
+	out = append(out, h.Samples[h.Next:]...)
+	return append(out, h.Samples[:h.Next]...)
+}
+
+func (h *DelayHistory) stats() DelayStats {
+	samples := h.ordered()
+	if len(samples) == 0 {
+		return DelayStats{}
+	}
+	vals := make([]float64, len(samples))
+	jitter := 0.0
+	for i, smp := range samples {
+		vals[i] = float64(smp.DelayMs)
+		if i > 0 {
+			jitter += math.Abs(vals[i] - vals[i-1])
+		}
+	}
+	if len(vals) > 1 {
+		jitter /= float64(len(vals) - 1)
+	}
+	sort.Float64s(vals)
+	return DelayStats{
+		Count:    h.Count,
+		LastMs:   samples[len(samples)-1].DelayMs,
+		EWMAMs:   h.EWMA,
+		P50Ms:    percentile(vals, 0.50),
+		P95Ms:    percentile(vals, 0.95),
+		JitterMs: jitter,
+	}
+}
+
+// percentile：sorted 已升序，线性插值
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// recordDelayLocked 记录一次测量：写入历史并更新 lastDelay（老字段，兼容展示）
+func (s *Store) recordDelayLocked(instanceID string, delayMs int, now time.Time) {
+	h := s.delayHist[instanceID]
+	if h == nil {
+		h = &DelayHistory{}
+		s.delayHist[instanceID] = h
+	}
+	h.add(DelaySample{At: now, DelayMs: delayMs})
+	s.lastDelay[instanceID] = delayMs
+}
+
+// recordPlanDelaysLocked 把一次分配里 client 实测的 delay 记入历史（兜底生成的 0 不记）
+func (s *Store) recordPlanDelaysLocked(plan *allocPlan) {
+	if plan.synthetic {
+		return
+	}
+	now := time.Now()
+	for _, c := range plan.cands {
+		s.recordDelayLocked(c.m.InstanceID, c.measuredMs, now)
+		s.consumePingTokenLocked(c.m.PingToken, now)
+	}
+}
+
+// effectiveDelayLocked 按 stat 给出打分用的 delay：把本次测量值视作最新样本（不落库）后取统计量；
+// 历史为空或 stat=measured 时直接用本次测量值
+func (s *Store) effectiveDelayLocked(instanceID string, measured int, stat string) int {
+	if stat == "" || stat == DelayStatMeasured {
+		return measured
+	}
+	h := DelayHistory{}
+	if old := s.delayHist[instanceID]; old != nil {
+		h = *old
+		h.Samples = append([]DelaySample(nil), old.Samples...)
+	}
+	h.add(DelaySample{At: time.Now(), DelayMs: measured})
+	st := h.stats()
+
+	var v float64
+	switch stat {
+	case DelayStatLast:
+		v = float64(st.LastMs)
+	case DelayStatEWMA:
+		v = st.EWMAMs
+	case DelayStatP50:
+		v = st.P50Ms
+	case DelayStatP95:
+		v = st.P95Ms
+	default:
+		return measured
+	}
+	return int(math.Round(v))
+}
+
+// smoothedDelayLocked 给 c-ps view 用：有历史取 EWMA，否则退回 lastDelay
+func (s *Store) smoothedDelayLocked(instanceID string) (int, bool) {
+	if h := s.delayHist[instanceID]; h != nil && h.Count > 0 {
+		return int(math.Round(h.EWMA)), true
+	}
+	d, ok := s.lastDelay[instanceID]
+	return d, ok
+}
+
+func (s *Store) DelayHistoryOf(instanceID string) (InstanceDelayResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := s.delayHist[instanceID]
+	if h == nil {
+		return InstanceDelayResponse{}, false
+	}
+	return InstanceDelayResponse{
+		InstanceID: instanceID,
+		Stats:      h.stats(),
+		Samples:    h.ordered(),
+	}, true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDelayHistoryEWMAAndPercentiles(t *testing.T) {
+	var h DelayHistory
+	want := 0.0
+	for i, d := range []int{100, 200, 100, 400} {
+		h.add(DelaySample{DelayMs: d})
+		if i == 0 {
+			want = float64(d)
+		} else {
+			want = delayAlpha*float64(d) + (1-delayAlpha)*want
+		}
+	}
+	st := h.stats()
+	if math.Abs(st.EWMAMs-want) > 1e-9 {
+		t.Fatalf("ewma = %v, want %v", st.EWMAMs, want)
+	}
+	// 升序 100 100 200 400：p50 = 150，p95 = 200 + 0.85*200
+	if st.P50Ms != 150 || math.Abs(st.P95Ms-370) > 1e-9 {
+		t.Fatalf("p50 = %v, p95 = %v; want 150, 370", st.P50Ms, st.P95Ms)
+	}
+	if st.LastMs != 400 || st.Count != 4 {
+		t.Fatalf("last = %d, count = %d", st.LastMs, st.Count)
+	}
+	// |200-100| + |100-200| + |400-100| = 500，3 个间隔
+	if math.Abs(st.JitterMs-500.0/3) > 1e-9 {
+		t.Fatalf("jitter = %v", st.JitterMs)
+	}
+}
+
+// 环满后覆盖最旧的样本，ordered 仍按时间先后
+func TestDelayHistoryRingWraps(t *testing.T) {
+	var h DelayHistory
+	n := delayRingSize + 6
+	for i := 1; i <= n; i++ {
+		h.add(DelaySample{DelayMs: i})
+	}
+	got := h.ordered()
+	if len(got) != delayRingSize || got[0].DelayMs != 7 || got[len(got)-1].DelayMs != n {
+		t.Fatalf("ordered: len %d, first %d, last %d", len(got), got[0].DelayMs, got[len(got)-1].DelayMs)
+	}
+	if st := h.stats(); st.Count != n || st.P50Ms != float64(7+n)/2 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+// DelayStat 让一次异常的测量值不至于左右选择
+func TestDelayStatSmoothsSpike(t *testing.T) {
+	s := newTestStore(t,
+		Deployment{SiteName: "s1", Gas: 1, Cost: 1},
+		Deployment{SiteName: "s2", Gas: 1, Cost: 1},
+	)
+	s.mu.Lock()
+	for i := 0; i < 10; i++ {
+		s.recordDelayLocked("s1-a", 10, time.Now())
+		s.recordDelayLocked("s2-a", 30, time.Now())
+	}
+	s.mu.Unlock()
+
+	req := AllocateRequest{ServiceID: "svc", Measurements: []Measurement{
+		{SiteName: "s1", InstanceID: "s1-a", DelayMs: 200}, // 本次抖动：p50 看不出，EWMA（0.3）仍被拉到 67ms
+		{SiteName: "s2", InstanceID: "s2-a", DelayMs: 30},
+	}}
+	for stat, want := range map[string]string{DelayStatMeasured: "s2-a", DelayStatP50: "s1-a", DelayStatEWMA: "s2-a"} {
+		req.DelayStat = stat
+		ex, err := s.Explain(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ex.Chosen.InstanceID != want {
+			t.Errorf("%s: chosen %s, want %s", stat, ex.Chosen.InstanceID, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+// Explain 跑一遍和 Allocate 相同的筛选 + 打分，但不扣 Gas、不登记 allocation，
+// 返回每个候选的原始值/归一化值/分数，以及被排除实例的原因
+func (s *Store) Explain(req AllocateRequest) (ExplainResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plan, err := s.planLocked(req)
+	if err != nil {
+		return ExplainResponse{}, err
+	}
+	return plan.explain(), nil
+}
+
+func (p *allocPlan) explain() ExplainResponse {
+	out := ExplainResponse{
+		ServiceID:  p.req.ServiceID,
+		Strategy:   p.strategy,
+		Weights:    p.weights,
+		DelayStat:  p.delayStat,
+		Affinity:   p.affinity,
+		Candidates: make([]ScoredCandidate, 0, len(p.cands)),
+		Excluded:   p.excluded,
+	}
+	if out.Excluded == nil {
+		out.Excluded = []ExcludedCandidate{}
+	}
+	for i, c := range p.cands {
+		out.Candidates = append(out.Candidates, ScoredCandidate{
+			Rank:            i + 1,
+			SiteName:        c.m.SiteName,
+			InstanceID:      c.m.InstanceID,
+			Addr:            c.m.Addr,
+			Cost:            c.cost,
+			Price:           c.price,
+			DelayMs:         c.m.DelayMs,
+			MeasuredDelayMs: c.measuredMs,
+			ServerDelayMs:   c.serverMs,
+			ComputingTimeMs: c.computeMs,
+			Utilization:     c.util,
+			Available:       c.available,
+			Capacity:        c.capacity,
+			Health:          c.health,
+			Reliability:     c.reliability,
+			Normalized:      c.norm,
+			Score:           c.score,
+		})
+	}
+	if len(out.Candidates) > 0 {
+		chosen := out.Candidates[0]
+		out.Chosen = &chosen
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ====== invocation feedback ======
+// client 调用完实例后 POST /api/allocations/{id}/feedback 上报成功与否、端到端延迟、计算耗时。
+// 按实例累计（EWMA，平滑系数同 delay），Allocate 用它：
+//   - reliability：成功率 EWMA，作为打分维度（Weights.Reliability，越可靠越好）
+//   - 学到的计算耗时：样本够 minComputeSamples 后替代声明的 ComputingTime
+// 失败/成功同时喂给实例健康状态（见 health.go）。
+
+const minComputeSamples = 3
+
+var ErrFeedbackDuplicate = errors.New("feedback already reported")
+
+type InstanceFeedback struct {
+	Count        int       `json:"count"`
+	Successes    int       `json:"successes"`
+	Failures     int       `json:"failures"`
+	Reliability  float64   `json:"reliability"` // 成功率 EWMA 0~1
+	LatencyCount int       `json:"latencyCount"`
+	LatencyMs    float64   `json:"latencyMs"` // 端到端延迟 EWMA
+	ComputeCount int       `json:"computeCount"`
+	ComputeMs    float64   `json:"computeMs"` // 计算耗时 EWMA
+	LastAt       time.Time `json:"lastAt"`
+	LastError    string    `json:"lastError,omitempty"`
+}
+
+func ewma(prev float64, n int, v float64) float64 {
+	if n == 0 {
+		return v
+	}
+	return delayAlpha*v + (1-delayAlpha)*prev
+}
+
+// recordFeedbackLocked 累计一次调用结果并推进健康状态；调用方需持有 s.mu
+func (s *Store) recordFeedbackLocked(instanceID, allocationID string, fb FeedbackRequest, now time.Time) {
+	f := s.feedback[instanceID]
+	if f == nil {
+		f = &InstanceFeedback{}
+		s.feedback[instanceID] = f
+	}
+	ok := fb.Success != nil && *fb.Success
+
+	okVal := 0.0
+	if ok {
+		okVal = 1
+		f.Successes++
+	} else {
+		f.Failures++
+		f.LastError = fb.Error
+	}
+	f.Reliability = ewma(f.Reliability, f.Count, okVal)
+	f.Count++
+	if fb.LatencyMs > 0 {
+		f.LatencyMs = ewma(f.LatencyMs, f.LatencyCount, float64(fb.LatencyMs))
+		f.LatencyCount++
+	}
+	// 失败的调用计算耗时没有参考价值
+	if ok && fb.ComputeMs > 0 {
+		f.ComputeMs = ewma(f.ComputeMs, f.ComputeCount, float64(fb.ComputeMs))
+		f.ComputeCount++
+	}
+	f.LastAt = now
+
+	errMsg := fb.Error
+	if !ok && errMsg == "" {
+		errMsg = "invocation failed"
+	}
+	s.recordOutcomeLocked(instanceID, allocationID, ok, "invocation", errMsg, now)
+}
+
+// Feedback 记录某 allocation 的调用结果；每个 allocation 只收一次
+func (s *Store) Feedback(allocationID string, fb FeedbackRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fb.Success == nil {
+		return fmt.Errorf("%w: missing success", ErrBadRequest)
+	}
+	if fb.LatencyMs < 0 || fb.ComputeMs < 0 {
+		return fmt.Errorf("%w: latencyMs/computeMs must be >= 0", ErrBadRequest)
+	}
+
+	recs := s.allocations
+	rec, ok := recs[allocationID]
+	if !ok {
+		// 被抢占前已经调用过的结果同样有价值
+		recs = s.revoked
+		if rec, ok = recs[allocationID]; !ok {
+			return errors.New("allocation not found")
+		}
+	}
+	if rec.FeedbackReported {
+		return ErrFeedbackDuplicate
+	}
+	rec.FeedbackReported = true
+	rec.Tokens += max(fb.Tokens, 0)
+	rec.ComputeMs += fb.ComputeMs
+	recs[allocationID] = rec
+
+	now := time.Now()
+	s.recordFeedbackLocked(rec.InstanceID, allocationID, fb, now)
+	s.recordBanditLocked(rec, fb, now)
+	return nil
+}
+
+// reliabilityLocked 返回实例成功率 EWMA；没有反馈按 1（不惩罚新实例）
+func (s *Store) reliabilityLocked(instanceID string) float64 {
+	if f := s.feedback[instanceID]; f != nil && f.Count > 0 {
+		return f.Reliability
+	}
+	return 1
+}
+
+// learnedComputeLocked 返回反馈学到的计算耗时（ms）；样本不足返回 false
+func (s *Store) learnedComputeLocked(instanceID string) (float64, bool) {
+	f := s.feedback[instanceID]
+	if f == nil || f.ComputeCount < minComputeSamples {
+		return 0, false
+	}
+	return math.Round(f.ComputeMs), true
+}
+
+func (s *Store) FeedbackOf(instanceID string) (InstanceFeedback, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.feedback[instanceID]
+	if f == nil {
+		return InstanceFeedback{}, false
+	}
+	return *f, true
+}
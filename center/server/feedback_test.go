@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// 反馈学到的计算耗时在样本够 minComputeSamples 后替代声明的 ComputingTime
+func TestFeedbackLearnsComputeTime(t *testing.T) {
+	s := newTestStore(t,
+		Deployment{SiteName: "s1", Gas: 5, Cost: 1, ComputingTime: "1s", Instances: []Instance{{InstanceID: "s1-a", Capacity: 5}}},
+		Deployment{SiteName: "s2", Gas: 1, Cost: 1, ComputingTime: "500ms"},
+	)
+	req := AllocateRequest{ServiceID: "svc", Weights: &Weights{ComputeTime: 1}}
+	s1 := AllocateRequest{ServiceID: "svc", Measurements: []Measurement{{SiteName: "s1", InstanceID: "s1-a"}}}
+	chosen := func() ScoredCandidate {
+		t.Helper()
+		ex, err := s.Explain(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *ex.Chosen
+	}
+	report := func(ok bool, computeMs int) {
+		t.Helper()
+		resp := s.mustAllocate(t, s1)
+		if err := s.Feedback(resp.AllocationID, FeedbackRequest{Success: &ok, ComputeMs: computeMs}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 失败调用的计算耗时不计入
+	report(false, 10)
+	for i := 1; i < minComputeSamples; i++ {
+		report(true, 200)
+	}
+	if c := chosen(); c.InstanceID != "s2-a" {
+		t.Fatalf("with %d samples chose %s (%.0fms), want declared times to rank s2 first", minComputeSamples-1, c.InstanceID, c.ComputingTimeMs)
+	}
+
+	report(true, 200)
+	if c := chosen(); c.InstanceID != "s1-a" || c.ComputingTimeMs != 200 {
+		t.Fatalf("after learning chose %s (%.0fms), want s1-a at 200ms", c.InstanceID, c.ComputingTimeMs)
+	}
+	f, _ := s.FeedbackOf("s1-a")
+	if f.Count != minComputeSamples+1 || f.Failures != 1 || f.ComputeCount != minComputeSamples {
+		t.Fatalf("feedback %+v", f)
+	}
+}
+
+func TestFeedbackOncePerAllocation(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	resp := s.mustAllocate(t, AllocateRequest{ServiceID: "svc"})
+	ok := true
+	if err := s.Feedback(resp.AllocationID, FeedbackRequest{}); !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("missing success: %v", err)
+	}
+	if err := s.Feedback(resp.AllocationID, FeedbackRequest{Success: &ok}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Feedback(resp.AllocationID, FeedbackRequest{Success: &ok}); !errors.Is(err, ErrFeedbackDuplicate) {
+		t.Fatalf("second feedback: %v", err)
+	}
+}
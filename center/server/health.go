@@ -0,0 +1,218 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ====== instance health & circuit breaker ======
+// 每个实例一份健康状态，由 center 探测结果和调用反馈（见 feedback.go）共同驱动：
+//   - closed：正常分配；连续调用失败或连续探测失败达到 breakerThreshold 次 → open
+//   - open：不再分配，冷却 breakerCooldown 后 → half-open
+//   - half-open：只放行一个试探 allocation；试探的调用结果成功 → closed，失败 → 重新 open
+// 两种信号分开计数：/ping 由 site-gateway 直接应答、不经过模型服务，
+// 所以探测成功只说明网关可达，不清零调用失败，也不关闭断路器；探测失败（网关不可达）仍可打开断路器。
+// 对外的 State：healthy（无失败）/ degraded（有失败但断路器未打开）/ down（open 或 half-open）
+
+const (
+	HealthHealthy  = "healthy"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+
+	defaultBreakerThreshold = 3
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+type InstanceHealth struct {
+	InstanceID          string    `json:"instanceId"`
+	State               string    `json:"state"`
+	Breaker             string    `json:"breaker"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"` // 连续调用失败
+	ProbeFailures       int       `json:"probeFailures"`       // 连续探测失败
+	LastFailureAt       time.Time `json:"lastFailureAt"`
+	LastSuccessAt       time.Time `json:"lastSuccessAt"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastSource          string    `json:"lastSource,omitempty"` // probe | invocation
+	OpenedAt            time.Time `json:"openedAt"`
+	TrialAllocationID   string    `json:"trialAllocationId,omitempty"` // half-open 期间放行的那个 allocation
+}
+
+// BREAKER_FAILURES 连续失败阈值
+func breakerThresholdFromEnv() int {
+	if n, err := strconv.Atoi(os.Getenv("BREAKER_FAILURES")); err == nil && n > 0 {
+		return n
+	}
+	return defaultBreakerThreshold
+}
+
+var breakerThreshold = breakerThresholdFromEnv()
+
+func (s *Store) healthLocked(instanceID string) *InstanceHealth {
+	h := s.health[instanceID]
+	if h == nil {
+		h = &InstanceHealth{InstanceID: instanceID, State: HealthHealthy, Breaker: BreakerClosed}
+		s.health[instanceID] = h
+	}
+	return h
+}
+
+// advanceBreakerLocked 处理时间驱动的转换：open 冷却结束 → half-open
+func (s *Store) advanceBreakerLocked(h *InstanceHealth, now time.Time) {
+	if h.Breaker == BreakerOpen && now.Sub(h.OpenedAt) >= s.breakerCooldown {
+		h.Breaker = BreakerHalfOpen
+		h.TrialAllocationID = ""
+		log.Printf("breaker: instance %s half-open", h.InstanceID)
+	}
+	h.State = healthState(h)
+}
+
+func healthState(h *InstanceHealth) string {
+	switch {
+	case h.Breaker != BreakerClosed:
+		return HealthDown
+	case h.ConsecutiveFailures > 0 || h.ProbeFailures > 0:
+		return HealthDegraded
+	default:
+		return HealthHealthy
+	}
+}
+
+// recordOutcomeLocked 记录一次调用结果（source: invocation），推进断路器。
+// half-open 时只有试探 allocation（allocationID == TrialAllocationID）的结果决定关闭还是重新打开
+func (s *Store) recordOutcomeLocked(instanceID, allocationID string, ok bool, source, errMsg string, now time.Time) {
+	h := s.healthLocked(instanceID)
+	s.advanceBreakerLocked(h, now)
+	h.LastSource = source
+	trial := h.Breaker == BreakerHalfOpen && h.TrialAllocationID != "" && h.TrialAllocationID == allocationID
+
+	if ok {
+		h.LastSuccessAt = now
+		if h.Breaker != BreakerClosed && !trial {
+			// open 期间或非试探的成功不提前关断路器，冷却结束后由 half-open 试探决定
+			return
+		}
+		h.LastError = ""
+		h.ConsecutiveFailures = 0
+		if trial {
+			log.Printf("breaker: instance %s closed (trial %s ok)", instanceID, allocationID)
+		}
+		h.Breaker = BreakerClosed
+		h.OpenedAt = time.Time{}
+		h.TrialAllocationID = ""
+		h.State = healthState(h)
+		return
+	}
+
+	h.LastFailureAt = now
+	h.LastError = errMsg
+	h.ConsecutiveFailures++
+	switch {
+	case trial:
+		// 试探失败：重新打开，重新计冷却
+		s.openBreakerLocked(h, now)
+		log.Printf("breaker: instance %s re-opened (trial %s: %s)", instanceID, allocationID, errMsg)
+	case h.Breaker == BreakerClosed && h.ConsecutiveFailures >= breakerThreshold:
+		s.openBreakerLocked(h, now)
+		log.Printf("breaker: instance %s opened after %d failures (%s: %s)", instanceID, h.ConsecutiveFailures, source, errMsg)
+	}
+	h.State = healthState(h)
+}
+
+// recordProbeOutcomeLocked 记录一次探测结果。探测成功只清零探测失败计数；
+// 连续探测失败达到阈值时打开断路器，half-open 期间探测失败重新打开
+func (s *Store) recordProbeOutcomeLocked(instanceID string, ok bool, errMsg string, now time.Time) {
+	h := s.healthLocked(instanceID)
+	s.advanceBreakerLocked(h, now)
+
+	if ok {
+		h.ProbeFailures = 0
+		h.State = healthState(h)
+		return
+	}
+
+	h.LastSource = "probe"
+	h.LastFailureAt = now
+	h.LastError = errMsg
+	h.ProbeFailures++
+	switch {
+	case h.Breaker == BreakerHalfOpen:
+		s.openBreakerLocked(h, now)
+		log.Printf("breaker: instance %s re-opened (probe: %s)", instanceID, errMsg)
+	case h.Breaker == BreakerClosed && h.ProbeFailures >= breakerThreshold:
+		s.openBreakerLocked(h, now)
+		log.Printf("breaker: instance %s opened after %d probe failures (%s)", instanceID, h.ProbeFailures, errMsg)
+	}
+	h.State = healthState(h)
+}
+
+func (s *Store) openBreakerLocked(h *InstanceHealth, now time.Time) {
+	h.Breaker = BreakerOpen
+	h.OpenedAt = now
+	h.TrialAllocationID = ""
+}
+
+// allocatableLocked：断路器是否允许再分配到该实例；不允许时返回排除原因
+func (s *Store) allocatableLocked(instanceID string, now time.Time) (bool, string) {
+	h := s.health[instanceID]
+	if h == nil {
+		return true, ""
+	}
+	s.advanceBreakerLocked(h, now)
+	switch h.Breaker {
+	case BreakerOpen:
+		return false, "circuit open"
+	case BreakerHalfOpen:
+		if h.TrialAllocationID != "" {
+			return false, "circuit half-open: trial in flight"
+		}
+	}
+	return true, ""
+}
+
+// noteAllocatedLocked：half-open 时把这次 allocation 记为试探
+func (s *Store) noteAllocatedLocked(instanceID, allocationID string) {
+	if h := s.health[instanceID]; h != nil && h.Breaker == BreakerHalfOpen && h.TrialAllocationID == "" {
+		h.TrialAllocationID = allocationID
+	}
+}
+
+// noteDroppedLocked：试探 allocation 没带结果就结束了（release/过期/抢占），让出试探名额
+func (s *Store) noteDroppedLocked(instanceID, allocationID string) {
+	if h := s.health[instanceID]; h != nil && h.TrialAllocationID == allocationID {
+		h.TrialAllocationID = ""
+	}
+}
+
+// healthOfLocked 返回实例当前健康状态（没有记录视为 healthy/closed）
+func (s *Store) healthOfLocked(instanceID string, now time.Time) InstanceHealth {
+	h := s.health[instanceID]
+	if h == nil {
+		return InstanceHealth{InstanceID: instanceID, State: HealthHealthy, Breaker: BreakerClosed}
+	}
+	s.advanceBreakerLocked(h, now)
+	return *h
+}
+
+func (s *Store) HealthOf(instanceID string) InstanceHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.healthOfLocked(instanceID, time.Now())
+}
+
+// withHealthLocked 复制实例列表并填上健康状态（不改 store 里的数据）
+func (s *Store) withHealthLocked(insts []Instance, now time.Time) []Instance {
+	out := make([]Instance, len(insts))
+	for i, inst := range insts {
+		h := s.healthOfLocked(inst.InstanceID, now)
+		inst.Health = h.State
+		inst.Breaker = h.Breaker
+		out[i] = inst
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 让 open 的断路器立即过冷却期
+func (s *Store) expireCooldown(instanceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health[instanceID].OpenedAt = time.Now().Add(-2 * s.breakerCooldown)
+}
+
+func (s *Store) feedback1(t *testing.T, allocationID string, ok bool) {
+	t.Helper()
+	if err := s.Feedback(allocationID, FeedbackRequest{Success: &ok}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 探测成功（网关 /ping）不能清零调用失败
+func TestProbeSuccessDoesNotResetInvocationFailures(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	s.mu.Lock()
+	for i := 0; i < breakerThreshold; i++ {
+		s.recordOutcomeLocked("s1-a", "", false, "invocation", "upstream 502", time.Now())
+		s.recordProbeOutcomeLocked("s1-a", true, "", time.Now())
+	}
+	s.mu.Unlock()
+
+	if h := s.HealthOf("s1-a"); h.Breaker != BreakerOpen || h.ConsecutiveFailures != breakerThreshold {
+		t.Fatalf("health %+v, want open with %d failures", h, breakerThreshold)
+	}
+}
+
+// 连续探测失败（网关不可达）仍然打开断路器，探测恢复只清零探测计数
+func TestProbeFailuresOpenBreaker(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	s.mu.Lock()
+	for i := 0; i < breakerThreshold; i++ {
+		s.recordProbeOutcomeLocked("s1-a", false, "connection refused", time.Now())
+	}
+	s.recordProbeOutcomeLocked("s1-a", true, "", time.Now())
+	s.mu.Unlock()
+
+	if h := s.HealthOf("s1-a"); h.Breaker != BreakerOpen || h.ProbeFailures != 0 {
+		t.Fatalf("health %+v, want open with probeFailures reset", h)
+	}
+}
+
+// open → half-open → 只有试探 allocation 的结果能关闭或重新打开
+func TestBreakerHalfOpenTrial(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 3, Cost: 1, Instances: []Instance{{InstanceID: "s1-a", Capacity: 3}}})
+	req := AllocateRequest{ServiceID: "svc"}
+	before, err := s.Allocate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	for i := 0; i < breakerThreshold; i++ {
+		s.recordOutcomeLocked("s1-a", "", false, "invocation", "boom", time.Now())
+	}
+	s.mu.Unlock()
+	if _, err := s.Allocate(context.Background(), req); err == nil {
+		t.Fatal("allocated on an open breaker")
+	}
+
+	s.expireCooldown("s1-a")
+	s.mu.Lock()
+	s.recordProbeOutcomeLocked("s1-a", true, "", time.Now())
+	s.mu.Unlock()
+	if h := s.HealthOf("s1-a"); h.Breaker != BreakerHalfOpen {
+		t.Fatalf("breaker %s after cooldown + probe ok, want half-open", h.Breaker)
+	}
+
+	trial, err := s.Allocate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Allocate(context.Background(), req); err == nil {
+		t.Fatal("second allocation while trial in flight")
+	}
+	// 打开前发出的 allocation 的成功不算试探
+	s.feedback1(t, before.AllocationID, true)
+	if h := s.HealthOf("s1-a"); h.Breaker != BreakerHalfOpen {
+		t.Fatalf("breaker %s after non-trial success, want half-open", h.Breaker)
+	}
+	s.feedback1(t, trial.AllocationID, false)
+	if h := s.HealthOf("s1-a"); h.Breaker != BreakerOpen {
+		t.Fatalf("breaker %s after failed trial, want open", h.Breaker)
+	}
+
+	s.expireCooldown("s1-a")
+	trial, err = s.Allocate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.feedback1(t, trial.AllocationID, true)
+	if h := s.HealthOf("s1-a"); h.Breaker != BreakerClosed || h.ConsecutiveFailures != 0 {
+		t.Fatalf("health %+v after successful trial, want closed", h)
+	}
+}
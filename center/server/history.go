@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ====== allocation history / audit ======
+// allocation 生命周期的每个事件（分配、续租、结束）追加一条到 dataDir/history.jsonl（只追加，不改写），
+// 内存里保留一份供 /api/allocations 查询；启动时从文件重新加载。
+// allocated / reserved 事件带完整打分上下文（策略、权重、各候选分数、被排除实例及原因），
+// 用来回答“某次请求当时为什么分到了这个实例”。结束事件的类型与账本的 EndReason 一致。
+
+const (
+	EventAllocated = "allocated"
+	EventReserved  = "reserved"  // reserve 得到的报价，commit 前不算分配
+	EventCommitted = "committed" // reservation 转为正式 allocation
+	EventRenewed   = "renewed"
+	// 结束事件：EndReleased / EndExpired / EndRevoked / EndAborted / EndCancelled（见 ledger.go）
+
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+type AllocationEvent struct {
+	Seq          int              `json:"seq"`
+	Type         string           `json:"type"`
+	At           time.Time        `json:"at"`
+	AllocationID string           `json:"allocationId"`
+	ServiceID    string           `json:"serviceId"`
+	SiteName     string           `json:"siteName"`
+	InstanceID   string           `json:"instanceId"`
+	ClientID     string           `json:"clientId"`
+	TenantID     string           `json:"tenantId,omitempty"`
+	Priority     string           `json:"priority,omitempty"`
+	Gas          int              `json:"gas"`
+	Price        float64          `json:"price"`
+	ExpiresAt    *time.Time       `json:"expiresAt,omitempty"` // 事件发生后的租约到期时间；结束事件没有
+	Reason       string           `json:"reason,omitempty"`    // 抢占原因等
+	Scoring      *ExplainResponse `json:"scoring,omitempty"`
+}
+
+type HistoryFilter struct {
+	AllocationID string
+	ServiceID    string
+	SiteName     string
+	InstanceID   string
+	ClientID     string
+	TenantID     string
+	Type         string
+	From, To     time.Time // 按事件时间过滤，[From, To)；零值不限
+}
+
+type HistoryPage struct {
+	Events     []AllocationEvent `json:"events"`
+	Total      int               `json:"total"`
+	Offset     int               `json:"offset"`
+	Limit      int               `json:"limit"`
+	NextOffset int               `json:"nextOffset,omitempty"` // 0 表示没有下一页
+}
+
+func (s *Store) historyPath() string { return filepath.Join(s.dataDir, "history.jsonl") }
+
+// loadHistory 启动时读回已有事件；坏行跳过
+func (s *Store) loadHistory() {
+	f, err := os.Open(s.historyPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024) // 带打分上下文的行可能较长
+	for sc.Scan() {
+		var e AllocationEvent
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		s.history = append(s.history, e)
+	}
+}
+
+// recordEventLocked 追加一条生命周期事件；调用方需持有 s.mu
+func (s *Store) recordEventLocked(typ, allocationID string, rec AllocationRecord, scoring *ExplainResponse, now time.Time) {
+	e := AllocationEvent{
+		Seq:          len(s.history) + 1,
+		Type:         typ,
+		At:           now,
+		AllocationID: allocationID,
+		ServiceID:    rec.ServiceID,
+		SiteName:     rec.SiteName,
+		InstanceID:   rec.InstanceID,
+		ClientID:     rec.ClientID,
+		TenantID:     rec.TenantID,
+		Priority:     rec.Priority,
+		Gas:          rec.gas(),
+		Price:        rec.Price,
+		Reason:       rec.RevokeReason,
+		Scoring:      scoring,
+	}
+	switch typ {
+	case EventAllocated, EventReserved, EventCommitted, EventRenewed:
+		exp := rec.ExpiresAt
+		e.ExpiresAt = &exp
+	}
+	s.history = append(s.history, e)
+
+	_ = os.MkdirAll(s.dataDir, 0755)
+	if err := appendJSONLine(s.historyPath(), e); err != nil {
+		log.Printf("history append failed: %v", err)
+	}
+}
+
+// scoringFor 生成写入历史的打分上下文；chosen 为实际选中的实例（batch 时不一定是第一名）
+func (p *allocPlan) scoringFor(instanceID string) *ExplainResponse {
+	ex := p.explain()
+	ex.Chosen = nil
+	for i := range ex.Candidates {
+		if ex.Candidates[i].InstanceID == instanceID {
+			ex.Chosen = &ex.Candidates[i]
+			break
+		}
+	}
+	return &ex
+}
+
+func (f HistoryFilter) match(e AllocationEvent) bool {
+	if f.AllocationID != "" && e.AllocationID != f.AllocationID {
+		return false
+	}
+	if f.ServiceID != "" && e.ServiceID != f.ServiceID {
+		return false
+	}
+	if f.SiteName != "" && e.SiteName != f.SiteName {
+		return false
+	}
+	if f.InstanceID != "" && e.InstanceID != f.InstanceID {
+		return false
+	}
+	if f.ClientID != "" && e.ClientID != f.ClientID {
+		return false
+	}
+	if f.TenantID != "" && e.TenantID != f.TenantID {
+		return false
+	}
+	if f.Type != "" && e.Type != f.Type {
+		return false
+	}
+	if !f.From.IsZero() && e.At.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.At.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// History 按时间倒序分页返回匹配的事件
+func (s *Store) History(f HistoryFilter, offset, limit int) HistoryPage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	limit = min(limit, maxHistoryLimit)
+	offset = max(offset, 0)
+
+	page := HistoryPage{Events: []AllocationEvent{}, Offset: offset, Limit: limit}
+	for i := len(s.history) - 1; i >= 0; i-- {
+		e := s.history[i]
+		if !f.match(e) {
+			continue
+		}
+		if page.Total >= offset && len(page.Events) < limit {
+			page.Events = append(page.Events, e)
+		}
+		page.Total++
+	}
+	if offset+len(page.Events) < page.Total {
+		page.NextOffset = offset + len(page.Events)
+	}
+	return page
+}
+
+// parseIntParam 解析非负整数查询参数；空串返回 def
+func parseIntParam(v string, def int) (int, bool) {
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// seedHistory 写入 n 个事件，client 交替为 a / b，时间按分钟递增
+func seedHistory(s *Store, n int, base time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		client, typ := "a", EventAllocated
+		if i%2 == 1 {
+			client, typ = "b", EndReleased
+		}
+		rec := AllocationRecord{ServiceID: "svc", SiteName: "s1", InstanceID: "s1-a", ClientID: client}
+		s.recordEventLocked(typ, "alloc", rec, nil, base.Add(time.Duration(i)*time.Minute))
+	}
+}
+
+func seqs(p HistoryPage) []int {
+	out := []int{}
+	for _, e := range p.Events {
+		out = append(out, e.Seq)
+	}
+	return out
+}
+
+func TestHistoryFilterAndPagination(t *testing.T) {
+	s := newTestStore(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	seedHistory(s, 10, base)
+
+	// 最新的在前
+	p := s.History(HistoryFilter{}, 0, 3)
+	if got := seqs(p); p.Total != 10 || len(got) != 3 || got[0] != 10 || got[2] != 8 || p.NextOffset != 3 {
+		t.Fatalf("page 1: seqs %v, total %d, next %d", got, p.Total, p.NextOffset)
+	}
+	p = s.History(HistoryFilter{}, 9, 3)
+	if got := seqs(p); len(got) != 1 || got[0] != 1 || p.NextOffset != 0 {
+		t.Fatalf("last page: seqs %v, next %d", got, p.NextOffset)
+	}
+
+	p = s.History(HistoryFilter{ClientID: "b"}, 0, 0)
+	if p.Total != 5 || p.Limit != defaultHistoryLimit {
+		t.Fatalf("client b: total %d, limit %d", p.Total, p.Limit)
+	}
+	for _, e := range p.Events {
+		if e.ClientID != "b" || e.Type != EndReleased {
+			t.Fatalf("client b: unexpected event %+v", e)
+		}
+	}
+
+	// [From, To)：第 3 ~ 5 分钟，即 seq 4, 5, 6
+	p = s.History(HistoryFilter{From: base.Add(3 * time.Minute), To: base.Add(6 * time.Minute)}, 0, 0)
+	if got := seqs(p); len(got) != 3 || got[0] != 6 || got[2] != 4 {
+		t.Fatalf("time range: seqs %v", got)
+	}
+
+	if p := s.History(HistoryFilter{}, 0, maxHistoryLimit+100); p.Limit != maxHistoryLimit {
+		t.Fatalf("limit %d, want capped at %d", p.Limit, maxHistoryLimit)
+	}
+
+	// 重启后从 history.jsonl 读回
+	if got := NewStore().History(HistoryFilter{Type: EventAllocated}, 0, 0).Total; got != 5 {
+		t.Fatalf("reloaded %d allocated events, want 5", got)
+	}
+}
+
+func TestAllocationHistoryHandlerParams(t *testing.T) {
+	old := store
+	store = newTestStore(t)
+	defer func() { store = old }()
+	seedHistory(store, 4, time.Now())
+
+	for query, want := range map[string]int{
+		"?client=a&limit=1": http.StatusOK,
+		"?offset=-1":        http.StatusBadRequest,
+		"?limit=ten":        http.StatusBadRequest,
+		"?from=yesterday":   http.StatusBadRequest,
+		"?type=allocated&to=" + time.Now().Add(time.Hour).Format(time.RFC3339): http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		allocationHistoryHandler(rec, httptest.NewRequest(http.MethodGet, "/api/allocations"+query, nil))
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d (%s)", query, rec.Code, want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ====== Idempotency-Key ======
+// allocate / release 带 Idempotency-Key 头时，第一次成功（2xx）的响应按 scope+key 存进 Store（带 TTL，随快照落盘）；
+// 同一个 key 的重试直接回放原响应，不再扣 Gas。约定：
+//   - 同 key 但请求不同（method、path、query 或 body 任一不同）→ 422（key 被挪用）；
+//     path 要算进去：/api/reservations/{id}/commit|abort 共用一个 scope 且 body 为空
+//   - 同 key 的第一次请求还没结束（例如在排队等容量）→ 409
+//   - 失败响应不保存，客户端可以用同一个 key 重试
+
+const defaultIdempotencyTTL = time.Hour
+
+type IdempotencyEntry struct {
+	Fingerprint string          `json:"fingerprint"` // requestFingerprint(method, path, query, body)
+	Status      int             `json:"status"`
+	Body        json.RawMessage `json:"body"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	ExpiresAt   time.Time       `json:"expiresAt"`
+	InFlight    bool            `json:"-"` // 第一次请求还在处理中（不落盘）
+}
+
+const (
+	idemNew = iota
+	idemReplay
+	idemMismatch
+	idemInFlight
+)
+
+func idempotencyKey(scope, key string) string { return scope + ":" + key }
+
+// BeginIdempotent 查 key：没有（或已过期）则登记为 in-flight 并返回 idemNew
+func (s *Store) BeginIdempotent(scope, key, fingerprint string) (IdempotencyEntry, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	k := idempotencyKey(scope, key)
+	if e, ok := s.idempotency[k]; ok && (e.InFlight || now.Before(e.ExpiresAt)) {
+		switch {
+		case e.Fingerprint != fingerprint:
+			return *e, idemMismatch
+		case e.InFlight:
+			return *e, idemInFlight
+		default:
+			return *e, idemReplay
+		}
+	}
+	s.idempotency[k] = &IdempotencyEntry{Fingerprint: fingerprint, CreatedAt: now, InFlight: true}
+	return IdempotencyEntry{}, idemNew
+}
+
+// FinishIdempotent 保存成功响应；status 非 2xx 时删除 in-flight 标记，允许重试
+func (s *Store) FinishIdempotent(scope, key string, status int, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := idempotencyKey(scope, key)
+	e, ok := s.idempotency[k]
+	if !ok {
+		return
+	}
+	if status < 200 || status >= 300 {
+		delete(s.idempotency, k)
+		return
+	}
+	now := time.Now()
+	e.Status = status
+	e.Body = append(json.RawMessage(nil), bytes.TrimSpace(body)...)
+	e.ExpiresAt = now.Add(s.idempotencyTTL)
+	e.InFlight = false
+}
+
+func (s *Store) pruneIdempotencyLocked(now time.Time) {
+	for k, e := range s.idempotency {
+		if !e.InFlight && now.After(e.ExpiresAt) {
+			delete(s.idempotency, k)
+		}
+	}
+}
+
+// captureWriter 记录 handler 写出的状态码和 body，同时照常写给客户端
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (c *captureWriter) WriteHeader(code int) {
+	c.status = code
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	c.buf.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	head := r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n"
+	sum := sha256.Sum256(append([]byte(head), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+// withIdempotency 给会产生副作用的 POST handler 加上 Idempotency-Key 语义；没带 key 时原样透传
+func withIdempotency(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method != http.MethodPost || isDryRun(r) {
+			h(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "read body failed", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fp := requestFingerprint(r, body)
+
+		e, state := store.BeginIdempotent(scope, key, fp)
+		switch state {
+		case idemReplay:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(e.Status)
+			_, _ = w.Write(append(e.Body, '\n'))
+			return
+		case idemMismatch:
+			http.Error(w, "Idempotency-Key reused with a different request", http.StatusUnprocessableEntity)
+			return
+		case idemInFlight:
+			http.Error(w, "request with this Idempotency-Key is still in progress", http.StatusConflict)
+			return
+		}
+
+		cw := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+		h(cw, r)
+		store.FinishIdempotent(scope, key, cw.status, cw.buf.Bytes())
+		_ = store.SaveToDisk()
+	}
+}
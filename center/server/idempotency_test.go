@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 同一个 key 用在另一个 reservation（不同 path、同样的空 body）上应返回 422，而不是回放
+func TestIdempotencyFingerprintIncludesPath(t *testing.T) {
+	old := store
+	store = newTestStore(t)
+	defer func() { store = old }()
+
+	calls := 0
+	h := withIdempotency("reservation", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeJSON(w, map[string]any{"path": r.URL.Path})
+	})
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Idempotency-Key", "k1")
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	if rec := do("/api/reservations/a/commit"); rec.Code != http.StatusOK {
+		t.Fatalf("first: %d", rec.Code)
+	}
+	if rec := do("/api/reservations/a/commit"); rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry: %d replayed=%q", rec.Code, rec.Header().Get("Idempotent-Replayed"))
+	}
+	for _, path := range []string{"/api/reservations/b/commit", "/api/reservations/a/abort"} {
+		if rec := do(path); rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s with reused key: %d, want 422", path, rec.Code)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+}
+
+// 只有真正的 dry run 才绕过 Idempotency-Key；?dryRun=false 是真实分配，重试必须回放
+func TestIdempotencyDryRunFalseIsProtected(t *testing.T) {
+	old := store
+	store = newTestStore(t)
+	defer func() { store = old }()
+
+	calls := 0
+	h := withIdempotency("allocate", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeJSON(w, map[string]any{"n": calls})
+	})
+	do := func(query string) {
+		req := httptest.NewRequest(http.MethodPost, "/api/cps/allocate"+query, nil)
+		req.Header.Set("Idempotency-Key", "k"+query)
+		h(httptest.NewRecorder(), req)
+	}
+
+	for _, q := range []string{"?dryRun=false", "?dryRun=0"} {
+		calls = 0
+		do(q)
+		do(q)
+		if calls != 1 {
+			t.Errorf("%s: handler called %d times, want 1", q, calls)
+		}
+	}
+	calls = 0
+	do("?dryRun=true")
+	do("?dryRun=true")
+	if calls != 2 {
+		t.Errorf("dryRun=true: handler called %d times, want 2 (not cached)", calls)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ====== allocation lease ======
+// allocation 带租约：client 需在 ExpiresAt 之前调用 /api/allocations/{id}/renew，
+// 否则 reaper 会按 Release 的同一路径把 slot 还回去（防止 client 崩溃导致 Gas 泄漏）。
+
+const (
+	defaultLeaseTTL  = 60 * time.Second
+	defaultReapEvery = 5 * time.Second
+)
+
+var ErrLeaseExpired = errors.New("allocation lease expired")
+
+// LEASE_TTL_SEC 可覆盖默认租约时长
+func leaseTTLFromEnv() time.Duration {
+	return durationFromEnv("LEASE_TTL_SEC", defaultLeaseTTL)
+}
+
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return time.Duration(n) * time.Second
+}
+
+// Renew 把租约延长到 now+leaseTTL
+func (s *Store) Renew(allocationID string) (AllocationRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.allocations[allocationID]
+	if !ok {
+		if rv, ok := s.revoked[allocationID]; ok {
+			return rv, fmt.Errorf("%w: %s", ErrRevoked, rv.RevokeReason)
+		}
+		return AllocationRecord{}, errors.New("allocation not found")
+	}
+	if rec.Reserved {
+		// reservation 只能 commit/abort，不能靠续租一直占着
+		return rec, ErrReservationPending
+	}
+	now := time.Now()
+	if !rec.ExpiresAt.IsZero() && now.After(rec.ExpiresAt) {
+		// 已过期但 reaper 还没来得及回收：按过期处理，保持语义一致
+		_ = s.releaseLocked(allocationID, EndExpired)
+		return AllocationRecord{}, ErrLeaseExpired
+	}
+	rec.ExpiresAt = now.Add(s.leaseTTL)
+	s.allocations[allocationID] = rec
+	s.touchAffinityLocked(rec.ServiceID, rec.AffinityKey, rec.SiteName, rec.InstanceID, now)
+	s.recordEventLocked(EventRenewed, allocationID, rec, nil, now)
+	return rec, nil
+}
+
+// ReapExpired 回收所有到期的 allocation，返回被回收的 id
+func (s *Store) ReapExpired(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneRevokedLocked(now)
+	s.pruneAffinityLocked(now)
+	s.prunePingNoncesLocked(now)
+	s.pruneIdempotencyLocked(now)
+
+	var reaped []string
+	for aid, rec := range s.allocations {
+		if rec.ExpiresAt.IsZero() || now.Before(rec.ExpiresAt) {
+			continue
+		}
+		// 到期未 commit 的 reservation 与 abort 同样处理（不计费）
+		reason := EndExpired
+		if rec.Reserved {
+			reason = EndAborted
+		}
+		if err := s.releaseLocked(aid, reason); err == nil {
+			reaped = append(reaped, aid)
+		}
+	}
+	return reaped
+}
+
+// runLeaseReaper 后台周期回收过期租约；有回收才落盘
+func runLeaseReaper(s *Store, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for now := range t.C {
+		reaped := s.ReapExpired(now)
+		if len(reaped) == 0 {
+			continue
+		}
+		log.Printf("lease reaper: reclaimed %d expired allocation(s): %v", len(reaped), reaped)
+		if err := s.SaveToDisk(); err != nil {
+			log.Printf("lease reaper: save store failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// setExpiry 把 allocation 的租约到期时间改成 at（模拟时间流逝）
+func (s *Store) setExpiry(allocationID string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.allocations[allocationID]
+	rec.ExpiresAt = at
+	s.allocations[allocationID] = rec
+}
+
+func (s *Store) lastEvent(t *testing.T, allocationID string) AllocationEvent {
+	t.Helper()
+	page := s.History(HistoryFilter{AllocationID: allocationID}, 0, 1)
+	if len(page.Events) == 0 {
+		t.Fatalf("no history for %s", allocationID)
+	}
+	return page.Events[0]
+}
+
+// reaper 回收过期的 allocation：slot 归还、写 expired 事件
+func TestReaperReleasesExpiredAllocation(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	resp, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := resp.ExpiresAt
+
+	if reaped := s.ReapExpired(exp.Add(-time.Second)); len(reaped) != 0 {
+		t.Fatalf("reaped %v before expiry", reaped)
+	}
+	if got := s.available(t, "s1", "s1-a"); got != 0 {
+		t.Fatalf("available = %d before expiry, want 0", got)
+	}
+
+	reaped := s.ReapExpired(exp.Add(time.Second))
+	if len(reaped) != 1 || reaped[0] != resp.AllocationID {
+		t.Fatalf("reaped %v, want [%s]", reaped, resp.AllocationID)
+	}
+	if got := s.available(t, "s1", "s1-a"); got != 1 {
+		t.Fatalf("available = %d after reap, want 1", got)
+	}
+	if e := s.lastEvent(t, resp.AllocationID); e.Type != EndExpired {
+		t.Fatalf("last event %q, want %q", e.Type, EndExpired)
+	}
+}
+
+// 续租把到期时间推后，reaper 按新的到期时间回收
+func TestRenewExtendsLease(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	resp, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.setExpiry(resp.AllocationID, now.Add(time.Second))
+
+	rec, err := s.Renew(resp.AllocationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.ExpiresAt.After(now.Add(s.leaseTTL - time.Second)) {
+		t.Fatalf("renewed expiry %v, want about now+%v", rec.ExpiresAt, s.leaseTTL)
+	}
+	if e := s.lastEvent(t, resp.AllocationID); e.Type != EventRenewed {
+		t.Fatalf("last event %q, want %q", e.Type, EventRenewed)
+	}
+	if reaped := s.ReapExpired(now.Add(2 * time.Second)); len(reaped) != 0 {
+		t.Fatalf("reaped renewed allocation: %v", reaped)
+	}
+	if reaped := s.ReapExpired(rec.ExpiresAt.Add(time.Second)); len(reaped) != 1 {
+		t.Fatalf("reaped %v after renewed expiry, want 1", reaped)
+	}
+}
+
+// 租约已过期、reaper 还没跑时续租：按过期回收并报错
+func TestRenewAfterExpiry(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	resp, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.setExpiry(resp.AllocationID, time.Now().Add(-time.Second))
+
+	if _, err := s.Renew(resp.AllocationID); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("want ErrLeaseExpired, got %v", err)
+	}
+	if got := s.available(t, "s1", "s1-a"); got != 1 {
+		t.Fatalf("available = %d, want 1", got)
+	}
+	if e := s.lastEvent(t, resp.AllocationID); e.Type != EndExpired {
+		t.Fatalf("last event %q, want %q", e.Type, EndExpired)
+	}
+}
+
+// 没 commit 的 reservation 只占 reserveTTL，到期后 slot 归还（计费 / 事件见 ledger_test.go）
+func TestReaperReturnsExpiredReservationSlot(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	res, err := s.Reserve(context.Background(), AllocateRequest{ServiceID: "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reaped := s.ReapExpired(res.ExpiresAt.Add(-time.Second)); len(reaped) != 0 {
+		t.Fatalf("reaped %v before reservation expiry", reaped)
+	}
+	if reaped := s.ReapExpired(res.ExpiresAt.Add(time.Second)); len(reaped) != 1 {
+		t.Fatalf("reaped %v, want the reservation", reaped)
+	}
+	if got := s.available(t, "s1", "s1-a"); got != 1 {
+		t.Fatalf("available = %d, want 1", got)
+	}
+}
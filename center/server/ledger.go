@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ====== usage ledger ======
+// allocation 结束（release / 过期 / 抢占）时追加一条计费记录到 dataDir/ledger.jsonl（只追加，不改写），
+// 内存里保留一份供查询；启动时从文件重新加载。未 commit 的 reservation 和 client 断开后回滚的分配不计费。
+// 被抢占的 allocation 计费截止到 RevokedAt，但账本条目等 owner release（或被抢占记录保留期满）时才写，
+// 这样 owner 在抢占之后上报的用量（release 请求体 / feedback）也能记进去。
+
+const (
+	EndReleased  = "released"
+	EndExpired   = "expired"
+	EndRevoked   = "revoked"
+	EndAborted   = "aborted"   // reservation 被 abort 或到期未 commit（reaper 回收过期 reservation 也用这个）
+	EndCancelled = "cancelled" // 排队拿到后 client 已断开，回滚
+)
+
+type LedgerEntry struct {
+	Seq          int       `json:"seq"`
+	AllocationID string    `json:"allocationId"`
+	ServiceID    string    `json:"serviceId"`
+	SiteName     string    `json:"siteName"`
+	InstanceID   string    `json:"instanceId"`
+	ClientID     string    `json:"clientId"`
+	TenantID     string    `json:"tenantId,omitempty"`
+	Gas          int       `json:"gas"`
+	Price        float64   `json:"price"`  // 单 slot 生效价格
+	Amount       float64   `json:"amount"` // price × gas
+	StartAt      time.Time `json:"startAt"`
+	EndAt        time.Time `json:"endAt"`
+	EndReason    string    `json:"endReason"`
+	Tokens       int       `json:"tokens"`     // client 上报的 token 用量
+	ComputeSec   float64   `json:"computeSec"` // client 上报的计算耗时
+}
+
+// LedgerTotals 按 client / site 等维度汇总
+type LedgerTotals struct {
+	Key         string  `json:"key"`
+	Count       int     `json:"count"`
+	Gas         int     `json:"gas"`
+	Amount      float64 `json:"amount"`
+	Tokens      int     `json:"tokens"`
+	ComputeSec  float64 `json:"computeSec"`
+	DurationSec float64 `json:"durationSec"` // 持有时长合计
+}
+
+type LedgerFilter struct {
+	ServiceID string
+	SiteName  string
+	ClientID  string
+	TenantID  string
+	From, To  time.Time // 按 EndAt 过滤，[From, To)；零值不限
+}
+
+func (s *Store) ledgerPath() string { return filepath.Join(s.dataDir, "ledger.jsonl") }
+
+// loadLedger 启动时读回已有账本；坏行跳过
+func (s *Store) loadLedger() {
+	f, err := os.Open(s.ledgerPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var e LedgerEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		s.ledger = append(s.ledger, e)
+	}
+}
+
+// endAllocationLocked 在 allocation 结束时调用（slot 已归还）：记一条历史事件，计费的写入账本。
+// 被抢占的先不写账本，见 settleRevokedLocked
+func (s *Store) endAllocationLocked(allocationID string, rec AllocationRecord, reason string, now time.Time) {
+	s.recordEventLocked(reason, allocationID, rec, nil, now)
+	if rec.Reserved || reason == EndAborted || reason == EndCancelled || reason == EndRevoked {
+		return
+	}
+	s.appendLedgerLocked(allocationID, rec, reason, now)
+}
+
+// settleRevokedLocked 把被抢占的记录（含之后上报的用量）写入账本并删除；owner release 或保留期满时调用
+func (s *Store) settleRevokedLocked(allocationID string) {
+	rec, ok := s.revoked[allocationID]
+	if !ok {
+		return
+	}
+	delete(s.revoked, allocationID)
+	if !rec.Reserved {
+		s.appendLedgerLocked(allocationID, rec, EndRevoked, rec.RevokedAt)
+	}
+}
+
+func (s *Store) appendLedgerLocked(allocationID string, rec AllocationRecord, reason string, endAt time.Time) {
+	e := LedgerEntry{
+		Seq:          len(s.ledger) + 1,
+		AllocationID: allocationID,
+		ServiceID:    rec.ServiceID,
+		SiteName:     rec.SiteName,
+		InstanceID:   rec.InstanceID,
+		ClientID:     rec.ClientID,
+		TenantID:     rec.TenantID,
+		Gas:          rec.gas(),
+		Price:        rec.Price,
+		Amount:       math.Round(rec.Price*float64(rec.gas())*100) / 100,
+		StartAt:      rec.AllocatedAt,
+		EndAt:        endAt,
+		EndReason:    reason,
+		Tokens:       rec.Tokens,
+		ComputeSec:   float64(rec.ComputeMs) / 1000,
+	}
+	s.ledger = append(s.ledger, e)
+
+	_ = os.MkdirAll(s.dataDir, 0755)
+	if err := appendJSONLine(s.ledgerPath(), e); err != nil {
+		log.Printf("ledger append failed: %v", err)
+	}
+}
+
+// AddUsage 累加 client 上报的用量（active 或已被抢占、owner 还没 release 的 allocation；后者 release 时一并入账）
+func (s *Store) AddUsage(allocationID string, tokens, computeMs int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addUsageLocked(allocationID, tokens, computeMs)
+}
+
+func (s *Store) addUsageLocked(allocationID string, tokens, computeMs int) error {
+	if tokens < 0 || computeMs < 0 {
+		return fmt.Errorf("%w: usage must be >= 0", ErrBadRequest)
+	}
+	recs := s.allocations
+	rec, ok := recs[allocationID]
+	if !ok {
+		recs = s.revoked
+		if rec, ok = recs[allocationID]; !ok {
+			return errors.New("allocation not found")
+		}
+	}
+	rec.Tokens += tokens
+	rec.ComputeMs += computeMs
+	recs[allocationID] = rec
+	return nil
+}
+
+func (f LedgerFilter) match(e LedgerEntry) bool {
+	if f.ServiceID != "" && e.ServiceID != f.ServiceID {
+		return false
+	}
+	if f.SiteName != "" && e.SiteName != f.SiteName {
+		return false
+	}
+	if f.ClientID != "" && e.ClientID != f.ClientID {
+		return false
+	}
+	if f.TenantID != "" && e.TenantID != f.TenantID {
+		return false
+	}
+	if !f.From.IsZero() && e.EndAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.EndAt.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+func (s *Store) LedgerEntries(f LedgerFilter) []LedgerEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := []LedgerEntry{}
+	for _, e := range s.ledger {
+		if f.match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// LedgerTotalsBy 汇总：groupBy = client | tenant | site | service | instance
+func (s *Store) LedgerTotalsBy(groupBy string, f LedgerFilter) ([]LedgerTotals, error) {
+	var key func(LedgerEntry) string
+	switch groupBy {
+	case "client":
+		key = func(e LedgerEntry) string { return e.ClientID }
+	case "tenant":
+		key = func(e LedgerEntry) string { return e.TenantID }
+	case "site":
+		key = func(e LedgerEntry) string { return e.SiteName }
+	case "service":
+		key = func(e LedgerEntry) string { return e.ServiceID }
+	case "instance":
+		key = func(e LedgerEntry) string { return e.InstanceID }
+	default:
+		return nil, fmt.Errorf("%w: groupBy must be client|tenant|site|service|instance", ErrBadRequest)
+	}
+
+	byKey := map[string]*LedgerTotals{}
+	for _, e := range s.LedgerEntries(f) {
+		k := key(e)
+		t := byKey[k]
+		if t == nil {
+			t = &LedgerTotals{Key: k}
+			byKey[k] = t
+		}
+		t.Count++
+		t.Gas += e.Gas
+		t.Amount += e.Amount
+		t.Tokens += e.Tokens
+		t.ComputeSec += e.ComputeSec
+		t.DurationSec += e.EndAt.Sub(e.StartAt).Seconds()
+	}
+	out := make([]LedgerTotals, 0, len(byKey))
+	for _, t := range byKey {
+		t.Amount = math.Round(t.Amount*100) / 100
+		t.DurationSec = math.Round(t.DurationSec*1000) / 1000
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+func fmtFloat(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+func writeLedgerCSV(w io.Writer, entries []LedgerEntry) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"seq", "allocationId", "serviceId", "siteName", "instanceId", "clientId", "tenantId",
+		"gas", "price", "amount", "startAt", "endAt", "endReason", "tokens", "computeSec"})
+	for _, e := range entries {
+		_ = cw.Write([]string{strconv.Itoa(e.Seq), e.AllocationID, e.ServiceID, e.SiteName, e.InstanceID, e.ClientID, e.TenantID,
+			strconv.Itoa(e.Gas), fmtFloat(e.Price), fmtFloat(e.Amount),
+			e.StartAt.Format(time.RFC3339), e.EndAt.Format(time.RFC3339), e.EndReason,
+			strconv.Itoa(e.Tokens), fmtFloat(e.ComputeSec)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeTotalsCSV(w io.Writer, groupBy string, totals []LedgerTotals) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{groupBy, "count", "gas", "amount", "tokens", "computeSec", "durationSec"})
+	for _, t := range totals {
+		_ = cw.Write([]string{t.Key, strconv.Itoa(t.Count), strconv.Itoa(t.Gas), fmtFloat(t.Amount),
+			strconv.Itoa(t.Tokens), fmtFloat(t.ComputeSec), fmtFloat(t.DurationSec)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseTimeParam 接受 RFC3339 或 unix 秒；空串返回零值
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: bad time %q (RFC3339 or unix seconds)", ErrBadRequest, v)
+}
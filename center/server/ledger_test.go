@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 抢占后 owner 上报的用量要进账本
+func TestLedgerRecordsUsageReportedAfterRevoke(t *testing.T) {
+	s, victim := newPreemptStore(t)
+	if _, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc", ClientID: "high", Priority: PriorityInteractive}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddUsage(victim.AllocationID, 500, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Release(victim.AllocationID); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := s.LedgerEntries(LedgerFilter{ClientID: "low"})
+	if len(entries) != 1 {
+		t.Fatalf("%d ledger entries for victim, want 1", len(entries))
+	}
+	if e := entries[0]; e.EndReason != EndRevoked || e.Tokens != 500 || e.ComputeSec != 1 {
+		t.Fatalf("entry = %+v, want revoked with 500 tokens / 1s", e)
+	}
+}
+
+// reaper 回收过期 reservation 与 abort 同样处理：不计费，历史里记 aborted
+func TestReaperAbortsExpiredReservation(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	res, err := s.Reserve(context.Background(), AllocateRequest{ServiceID: "svc", ClientID: "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reaped := s.ReapExpired(time.Now().Add(time.Hour)); len(reaped) != 1 {
+		t.Fatalf("reaped %v", reaped)
+	}
+	if n := len(s.LedgerEntries(LedgerFilter{})); n != 0 {
+		t.Fatalf("%d ledger entries, want 0", n)
+	}
+	page := s.History(HistoryFilter{AllocationID: res.ReservationID}, 0, 0)
+	if len(page.Events) == 0 || page.Events[0].Type != EndAborted {
+		t.Fatalf("latest event = %+v, want %s", page.Events, EndAborted)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -38,13 +39,42 @@ func main() {
 
 	// cps 相关 handler：逻辑在 store.Candidates/Allocate/Release
 	mux.HandleFunc("/api/cps/candidates", withCORS(candidatesHandler))
-	mux.HandleFunc("/api/cps/allocate", withCORS(allocateHandler))
-	mux.HandleFunc("/api/allocations/release", withCORS(releaseHandler))
+	mux.HandleFunc("/api/cps/allocate", withCORS(withIdempotency("allocate", allocateHandler))) // ?dryRun=true 等同 explain
+	mux.HandleFunc("/api/cps/explain", withCORS(explainHandler))
+	mux.HandleFunc("/api/cps/reserve", withCORS(withIdempotency("reserve", reserveHandler)))
+	mux.HandleFunc("/api/reservations/", withCORS(withIdempotency("reservation", reservationActionHandler))) // /{id}/commit|abort
+	mux.HandleFunc("/api/allocations/release", withCORS(withIdempotency("release", releaseHandler)))
+	mux.HandleFunc("/api/allocations/", withCORS(allocationActionHandler)) // /api/allocations/{id}/renew|feedback
+	mux.HandleFunc("/api/allocations", withCORS(allocationHistoryHandler)) // 生命周期事件 ?service=&site=&client=&from=&to=&offset=&limit=
 	mux.HandleFunc("/api/cps/view", withCORS(cpsViewHandler))
+	mux.HandleFunc("/api/cps/strategies", withCORS(strategiesHandler))
+	mux.HandleFunc("/api/cps/bandit", withCORS(banditHandler)) // ?ServiceID=
+	mux.HandleFunc("/api/cps/queue", withCORS(queueHandler))  // ?ServiceID=
+	mux.HandleFunc("/api/cps/queue/", withCORS(queueHandler)) // /{waitId}
+
+	// 实例 delay 历史 / 探测状态 / 健康状态 / 调用反馈：/api/instances/{id}/delay|probe|health|feedback
+	mux.HandleFunc("/api/instances/", withCORS(instanceHandler))
+
+	// 配额：GET 列表 / POST 新增或更新；DELETE /api/quotas/{kind}/{id}
+	mux.HandleFunc("/api/quotas", withCORS(quotasHandler))
+	mux.HandleFunc("/api/quotas/", withCORS(quotaDeleteHandler))
+
+	// 计费账本：/api/ledger 明细，/api/ledger/totals?groupBy=client|site 汇总；?format=csv 导出
+	mux.HandleFunc("/api/ledger", withCORS(ledgerHandler))
+	mux.HandleFunc("/api/ledger/totals", withCORS(ledgerHandler))
+
+	// 花费预算：GET 列表 / POST 新增或更新；GET /api/budgets/{kind}/{id} 看已花/剩余，DELETE 删除
+	mux.HandleFunc("/api/budgets", withCORS(budgetsHandler))
+	mux.HandleFunc("/api/budgets/", withCORS(budgetItemHandler))
 
 	// 新增：点击卡片先发一条消息（demo 真实性）
 	mux.HandleFunc("/api/client/selection", withCORS(clientSelectionHandler))
 
+	// 后台回收过期租约
+	go runLeaseReaper(store, durationFromEnv("LEASE_REAP_SEC", defaultReapEvery))
+	// 后台主动探测实例 /ping（相对地址需配置 PROBE_BASE_URL 才能探测）
+	go runProber(store, os.Getenv("PROBE_BASE_URL"))
+
 	addr := ":" + port
 	log.Printf("center listening on %s", addr)
 	log.Fatal(http.ListenAndServe(addr, mux))
@@ -54,7 +84,7 @@ func withCORS(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,DELETE,OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Idempotency-Key")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -90,6 +120,12 @@ func servicesHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "missing ServiceID", http.StatusBadRequest)
 			return
 		}
+		if s.DefaultWeights != nil {
+			if _, err := normalizeWeights(*s.DefaultWeights); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 		store.mu.Lock()
 		store.services[s.ServiceID] = s
 		store.mu.Unlock()
@@ -159,6 +195,10 @@ func deploymentsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Gas/Cost must be >=0", http.StatusBadRequest)
 			return
 		}
+		if err := validatePricing(d.Pricing); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		// ---- auto build instances (critical) ----
 		// If UI didn't pass instances, derive from CSCI-ID like "site2-a|site2-b".
@@ -199,14 +239,9 @@ func deploymentsHandler(w http.ResponseWriter, r *http.Request) {
 		// -----------------------------------------
 
 		store.mu.Lock()
-		if _, ok := store.deployments[d.SiteName]; !ok {
-			store.deployments[d.SiteName] = map[string]*DeploymentState{}
-		}
-		// reset available gas; align with instance count to avoid "Gas>0 but no candidates"
-		store.deployments[d.SiteName][d.ServiceID] = &DeploymentState{
-			Deployment:   d,
-			GasAvailable: len(d.Instances),
-		}
+		// per-instance slots: capacity from instances (or Gas split across them),
+		// available = capacity minus slots still held by live allocations
+		store.putDeploymentLocked(d)
 		store.mu.Unlock()
 
 		_ = store.SaveToDisk()
@@ -261,24 +296,39 @@ func candidatesHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, CandidatesResponse{Candidates: list})
 }
 
+// isDryRun：?dryRun=true / 1 时只打分不分配（allocateHandler 与 withIdempotency 共用）
+func isDryRun(r *http.Request) bool {
+	v := r.URL.Query().Get("dryRun")
+	return v == "true" || v == "1"
+}
+
 func allocateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "POST only", http.StatusMethodNotAllowed)
 		return
 	}
+	if isDryRun(r) {
+		explainHandler(w, r)
+		return
+	}
 	var req AllocateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
-	resp, err := store.Allocate(req)
+	if req.Count > 1 {
+		list, err := store.AllocateBatch(r.Context(), req)
+		if err != nil {
+			writeAllocError(w, err)
+			return
+		}
+		_ = store.SaveToDisk()
+		writeJSON(w, BatchAllocateResponse{Allocations: list})
+		return
+	}
+	resp, err := store.Allocate(r.Context(), req)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		_ = json.NewEncoder(w).Encode(map[string]any{
-			"ok":    false,
-			"error": err.Error(),
-		})
+		writeAllocError(w, err)
 		return
 	}
 
@@ -287,6 +337,113 @@ func allocateHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, resp)
 }
 
+// explain / dry-run：完整打分但不扣 Gas
+func explainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	var req AllocateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	resp, err := store.Explain(req)
+	if err != nil {
+		writeAllocError(w, err)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// 两阶段分配第一步：预占 slot 并返回报价
+func reserveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	var req AllocateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	resp, err := store.Reserve(r.Context(), req)
+	if err != nil {
+		writeAllocError(w, err)
+		return
+	}
+
+	_ = store.SaveToDisk()
+
+	writeJSON(w, resp)
+}
+
+// /api/reservations/{id}/commit 与 /api/reservations/{id}/abort
+func reservationActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/reservations/"), "/")
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		http.Error(w, "need /api/reservations/{id}/commit|abort", http.StatusBadRequest)
+		return
+	}
+	id := parts[0]
+
+	var resp any
+	var err error
+	switch parts[1] {
+	case "commit":
+		resp, err = store.CommitReservation(id)
+	case "abort":
+		err = store.AbortReservation(id)
+		resp = map[string]any{"ok": true}
+	default:
+		http.Error(w, "unknown action: "+parts[1], http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrReservationExpired):
+			_ = store.SaveToDisk()
+			http.Error(w, err.Error(), http.StatusGone)
+		case errors.Is(err, ErrNotReserved):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+		return
+	}
+
+	_ = store.SaveToDisk()
+
+	writeJSON(w, resp)
+}
+
+// writeAllocError：参数错误 400，超预算 402，配额 429，等待超时 503，其余（无候选、永远放不下等）409
+func writeAllocError(w http.ResponseWriter, err error) {
+	status := http.StatusConflict
+	if errors.Is(err, ErrBadRequest) {
+		status = http.StatusBadRequest
+	}
+	if errors.Is(err, ErrWaitTimeout) {
+		status = http.StatusServiceUnavailable
+	}
+	if errors.Is(err, ErrQuotaExceeded) {
+		status = http.StatusTooManyRequests
+	}
+	if errors.Is(err, ErrBudgetExceeded) {
+		status = http.StatusPaymentRequired
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"ok":    false,
+		"error": err.Error(),
+	})
+}
+
 func releaseHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "POST only", http.StatusMethodNotAllowed)
@@ -301,6 +458,16 @@ func releaseHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing allocationId", http.StatusBadRequest)
 		return
 	}
+	// 先记调用结果和用量（需要 allocation 还在才能找到实例），再释放
+	if req.Success != nil {
+		_ = store.Feedback(req.AllocationID, FeedbackRequest{Success: req.Success, Error: req.Error})
+	}
+	if req.Tokens > 0 || req.ComputeMs > 0 {
+		if err := store.AddUsage(req.AllocationID, req.Tokens, req.ComputeMs); errors.Is(err, ErrBadRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	if err := store.Release(req.AllocationID); err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -311,6 +478,265 @@ func releaseHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]any{"ok": true})
 }
 
+// 排队情况：/api/cps/queue?ServiceID=xx 列表；/api/cps/queue/{waitId} 单个位置
+func queueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	waitID := strings.TrimPrefix(r.URL.Path, "/api/cps/queue")
+	waitID = strings.Trim(waitID, "/")
+	if waitID == "" {
+		writeJSON(w, map[string]any{"queue": store.QueueStatus(r.URL.Query().Get("ServiceID"))})
+		return
+	}
+	e, ok := store.QueuePosition(waitID)
+	if !ok {
+		http.Error(w, "not in queue", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, e)
+}
+
+func strategiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]any{"strategies": ScorerNames(), "default": defaultStrategy})
+}
+
+// bandit 策略（ucb1 / thompson）学到的 arm 统计
+func banditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]any{"arms": store.BanditArms(r.URL.Query().Get("ServiceID"))})
+}
+
+// /api/allocations/{id}（GET 状态）与 /api/allocations/{id}/renew
+func allocationActionHandler(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/api/allocations/")
+	parts := strings.Split(p, "/")
+	if len(parts) > 2 || strings.TrimSpace(parts[0]) == "" {
+		http.Error(w, "need /api/allocations/{id}[/renew|/feedback]", http.StatusBadRequest)
+		return
+	}
+	allocationID, action := parts[0], ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			http.Error(w, "GET only", http.StatusMethodNotAllowed)
+			return
+		}
+		st, ok := store.AllocationStatus(allocationID)
+		if !ok {
+			http.Error(w, "allocation not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, st)
+
+	case "renew":
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+			return
+		}
+		rec, err := store.Renew(allocationID)
+		if err != nil {
+			if errors.Is(err, ErrLeaseExpired) || errors.Is(err, ErrRevoked) {
+				_ = store.SaveToDisk()
+				http.Error(w, err.Error(), http.StatusGone)
+				return
+			}
+			if errors.Is(err, ErrReservationPending) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		_ = store.SaveToDisk()
+
+		writeJSON(w, RenewResponse{AllocationID: allocationID, ExpiresAt: rec.ExpiresAt})
+
+	case "feedback":
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+			return
+		}
+		var req FeedbackRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if err := store.Feedback(allocationID, req); err != nil {
+			switch {
+			case errors.Is(err, ErrBadRequest):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, ErrFeedbackDuplicate):
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			}
+			return
+		}
+
+		_ = store.SaveToDisk()
+
+		writeJSON(w, map[string]any{"ok": true})
+
+	default:
+		http.Error(w, "unknown action: "+action, http.StatusNotFound)
+	}
+}
+
+// -------- instance resources (delay / probe / health / feedback) --------
+func instanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/instances/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.Error(w, "need /api/instances/{id}/delay|probe|health|feedback", http.StatusBadRequest)
+		return
+	}
+	switch parts[1] {
+	case "delay":
+		resp, ok := store.DelayHistoryOf(parts[0])
+		if !ok {
+			http.Error(w, "no delay history", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, resp)
+
+	case "probe":
+		ps, ok := store.ProbeStateOf(parts[0])
+		if !ok {
+			http.Error(w, "not probed", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, ps)
+
+	case "health":
+		writeJSON(w, store.HealthOf(parts[0]))
+
+	case "feedback":
+		f, ok := store.FeedbackOf(parts[0])
+		if !ok {
+			http.Error(w, "no feedback", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, f)
+
+	default:
+		http.Error(w, "unknown resource: "+parts[1], http.StatusNotFound)
+	}
+}
+
+// -------- quotas --------
+func quotasHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]any{"quotas": store.ListQuotas()})
+
+	case http.MethodPost:
+		var q Quota
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if err := store.UpsertQuota(q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_ = store.SaveToDisk()
+
+		writeJSON(w, map[string]any{"ok": true})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func quotaDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/quotas/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.Error(w, "need /api/quotas/{kind}/{id}", http.StatusBadRequest)
+		return
+	}
+	store.DeleteQuota(parts[0], parts[1])
+
+	_ = store.SaveToDisk()
+
+	writeJSON(w, map[string]any{"ok": true})
+}
+
+// -------- budgets --------
+func budgetsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]any{"budgets": store.ListBudgets()})
+
+	case http.MethodPost:
+		var b Budget
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if err := store.UpsertBudget(b); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_ = store.SaveToDisk()
+
+		writeJSON(w, map[string]any{"ok": true})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func budgetItemHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/budgets/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.Error(w, "need /api/budgets/{kind}/{id}", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		st, ok := store.BudgetStatusOf(parts[0], parts[1])
+		if !ok {
+			http.Error(w, "no budget for "+parts[0]+" "+parts[1], http.StatusNotFound)
+			return
+		}
+		writeJSON(w, st)
+
+	case http.MethodDelete:
+		store.DeleteBudget(parts[0], parts[1])
+
+		_ = store.SaveToDisk()
+
+		writeJSON(w, map[string]any{"ok": true})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // -------- cps view --------
 func cpsViewHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -326,14 +752,17 @@ func cpsViewHandler(w http.ResponseWriter, r *http.Request) {
 	for _, bySvc := range store.deployments {
 		for _, st := range bySvc {
 
-			comp := ""
-			if svc, ok := store.services[st.Deployment.ServiceID]; ok {
-				comp = svc.ComputingTime
+			// 站点覆盖优先；解析成毫秒一并展示（-1 表示文本解析不出）
+			comp, compDur, ok := effectiveComputingTime(store.services[st.Deployment.ServiceID], st.Deployment)
+			compMs := int64(-1)
+			if ok {
+				compMs = compDur.Milliseconds()
 			}
 
 			minDelay := -1
 			for _, inst := range st.Deployment.Instances {
-				if d, ok := store.lastDelay[inst.InstanceID]; ok {
+				// 有历史用 EWMA，避免单次抖动
+				if d, ok := store.smoothedDelayLocked(inst.InstanceID); ok {
 					if minDelay == -1 || d < minDelay {
 						minDelay = d
 					}
@@ -343,13 +772,29 @@ func cpsViewHandler(w http.ResponseWriter, r *http.Request) {
 				minDelay = 0
 			}
 
+			insts := make([]InstanceOccupancy, 0, len(st.Deployment.Instances))
+			for _, inst := range store.withHealthLocked(st.Deployment.Instances, time.Now()) {
+				insts = append(insts, InstanceOccupancy{
+					InstanceID: inst.InstanceID,
+					Gas:        fmt.Sprintf("%d/%d", inst.Available, inst.Capacity),
+					Available:  inst.Available,
+					Capacity:   inst.Capacity,
+					Health:     inst.Health,
+					Breaker:    inst.Breaker,
+				})
+			}
+
 			rows = append(rows, CPSViewRow{
-				CS_ID:         st.Deployment.ServiceID,
-				CSCI_ID:       st.Deployment.CSCI_ID,
-				Gas:           fmt.Sprintf("%d/%d", st.GasAvailable, st.Deployment.Gas), // ★ 核心修改
-				Cost:          st.Deployment.Cost,
-				Computingtime: comp,
-				Networkdelay:  minDelay,
+				CS_ID:           st.Deployment.ServiceID,
+				CSCI_ID:         st.Deployment.CSCI_ID,
+				Gas:             fmt.Sprintf("%d/%d", st.GasAvailable, st.Deployment.Gas), // ★ 核心修改
+				Cost:            st.Deployment.Cost,
+				Computingtime:   comp,
+				Networkdelay:    minDelay,
+				ComputingTimeMs: compMs,
+				Utilization:     st.utilization(),
+				Price:           st.priceOf(time.Now()),
+				Instances:       insts,
 			})
 		}
 	}
@@ -357,6 +802,104 @@ func cpsViewHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]any{"cps": rows})
 }
 
+// -------- usage ledger --------
+func ledgerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	from, err := parseTimeParam(q.Get("from"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(q.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f := LedgerFilter{
+		ServiceID: q.Get("service"),
+		SiteName:  q.Get("site"),
+		ClientID:  q.Get("client"),
+		TenantID:  q.Get("tenant"),
+		From:      from,
+		To:        to,
+	}
+	csvOut := q.Get("format") == "csv"
+
+	if r.URL.Path == "/api/ledger/totals" {
+		groupBy := q.Get("groupBy")
+		if groupBy == "" {
+			groupBy = "client"
+		}
+		totals, err := store.LedgerTotalsBy(groupBy, f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if csvOut {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", "attachment; filename=ledger-totals-"+groupBy+".csv")
+			_ = writeTotalsCSV(w, groupBy, totals)
+			return
+		}
+		writeJSON(w, map[string]any{"groupBy": groupBy, "totals": totals})
+		return
+	}
+
+	entries := store.LedgerEntries(f)
+	if csvOut {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=ledger.csv")
+		_ = writeLedgerCSV(w, entries)
+		return
+	}
+	writeJSON(w, map[string]any{"entries": entries})
+}
+
+// -------- allocation history --------
+func allocationHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	from, err := parseTimeParam(q.Get("from"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(q.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, ok := parseIntParam(q.Get("offset"), 0)
+	if !ok {
+		http.Error(w, "bad offset", http.StatusBadRequest)
+		return
+	}
+	limit, ok := parseIntParam(q.Get("limit"), defaultHistoryLimit)
+	if !ok {
+		http.Error(w, "bad limit", http.StatusBadRequest)
+		return
+	}
+	f := HistoryFilter{
+		AllocationID: q.Get("allocation"),
+		ServiceID:    q.Get("service"),
+		SiteName:     q.Get("site"),
+		InstanceID:   q.Get("instance"),
+		ClientID:     q.Get("client"),
+		TenantID:     q.Get("tenant"),
+		Type:         q.Get("type"),
+		From:         from,
+		To:           to,
+	}
+	writeJSON(w, store.History(f, offset, limit))
+}
+
 // -------- client selection (NEW) --------
 func clientSelectionHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
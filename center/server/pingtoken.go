@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ====== signed delay measurements ======
+// 站点 /ping 返回短时有效的签名 token：base64url(payload) + "." + base64url(HMAC-SHA256(PING_SECRET, payload))，
+// payload = {"i": instanceId, "t": 签发时间 ms, "n": nonce, "r": 站点观测到的 RTT ms（-1 = 无）}。
+// client 测两次：第一次拿 token，第二次带 ?echo=<token> 回到站点，站点据此算出自己看到的 RTT 并签进新 token；
+// client 把新 token 随 measurement 的 pingToken 一起提交。
+//
+// center 配置了 PING_SECRET 才校验（否则保持原来信任 client 的行为）：签名、instanceId、新鲜度、nonce 防重放，
+// 以及 RTT 合理性——声称的 delay 不能低于站点观测 RTT 的 pingMinRTTRatio 倍，也不能超过 token 签发后流逝的时间。
+// 校验不过的 measurement 不参与打分、不进 delay 历史。
+// 新鲜度按请求到达时间算（AllocateRequest.arrivedAt）：排队等容量的请求每次重试重新打分时，token 不会因为排队而过期；
+// 相应地，已用 nonce 要多保留 maxWaitTimeout，防止排队中的请求在 nonce 被清理后重放同一个 token。
+
+const (
+	pingTokenTTL    = 30 * time.Second
+	pingClockSkew   = 2 * time.Second
+	pingMinRTTRatio = 0.5
+	pingRTTSlackMs  = 5 // 低 RTT 时的绝对容差
+)
+
+var pingSecret = []byte(os.Getenv("PING_SECRET"))
+
+var ErrPingToken = errors.New("delay rejected")
+
+type pingPayload struct {
+	InstanceID string `json:"i"`
+	IssuedAtMs int64  `json:"t"`
+	Nonce      string `json:"n"`
+	RTTMs      int64  `json:"r"`
+}
+
+func pingVerifyEnabled() bool { return len(pingSecret) > 0 }
+
+func parsePingToken(token string) (pingPayload, error) {
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return pingPayload{}, errors.New("malformed token")
+	}
+	want, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return pingPayload{}, errors.New("malformed signature")
+	}
+	mac := hmac.New(sha256.New, pingSecret)
+	mac.Write([]byte(body))
+	if !hmac.Equal(mac.Sum(nil), want) {
+		return pingPayload{}, errors.New("bad signature")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return pingPayload{}, errors.New("malformed payload")
+	}
+	var p pingPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return pingPayload{}, errors.New("malformed payload")
+	}
+	return p, nil
+}
+
+// verifyMeasurementLocked 校验一条 measurement 的 pingToken（now 为请求到达时间）；未开启校验时总是通过。
+// 只检查 nonce 是否已用，不标记（explain 不应消耗 token），标记在 recordPlanDelaysLocked 里做
+func (s *Store) verifyMeasurementLocked(m Measurement, now time.Time) error {
+	if !pingVerifyEnabled() {
+		return nil
+	}
+	if m.PingToken == "" {
+		return fmt.Errorf("%w: missing pingToken", ErrPingToken)
+	}
+	p, err := parsePingToken(m.PingToken)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPingToken, err)
+	}
+	if p.InstanceID != m.InstanceID {
+		return fmt.Errorf("%w: token issued by %s", ErrPingToken, p.InstanceID)
+	}
+	issued := time.UnixMilli(p.IssuedAtMs)
+	age := now.Sub(issued)
+	if age > pingTokenTTL || age < -pingClockSkew {
+		return fmt.Errorf("%w: token expired", ErrPingToken)
+	}
+	if exp, used := s.pingNonces[p.Nonce]; used && now.Before(exp) {
+		return fmt.Errorf("%w: token already used", ErrPingToken)
+	}
+
+	// RTT 合理性
+	if m.DelayMs < 0 {
+		return fmt.Errorf("%w: negative delay", ErrPingToken)
+	}
+	if p.RTTMs >= 0 && float64(m.DelayMs) < pingMinRTTRatio*float64(p.RTTMs)-pingRTTSlackMs {
+		return fmt.Errorf("%w: claimed %dms but site observed %dms", ErrPingToken, m.DelayMs, p.RTTMs)
+	}
+	if elapsed := (age + pingClockSkew).Milliseconds(); int64(m.DelayMs) > 2*elapsed {
+		return fmt.Errorf("%w: claimed %dms exceeds time since ping", ErrPingToken, m.DelayMs)
+	}
+	return nil
+}
+
+// consumePingTokenLocked 标记 token 已用（到 token 过期、且最长排队的请求也已结束为止）
+func (s *Store) consumePingTokenLocked(token string, now time.Time) {
+	if !pingVerifyEnabled() || token == "" {
+		return
+	}
+	if p, err := parsePingToken(token); err == nil {
+		s.pingNonces[p.Nonce] = time.UnixMilli(p.IssuedAtMs).Add(pingTokenTTL + pingClockSkew + maxWaitTimeout)
+	}
+}
+
+func (s *Store) prunePingNoncesLocked(now time.Time) {
+	for n, exp := range s.pingNonces {
+		if now.After(exp) {
+			delete(s.pingNonces, n)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func signTestPingToken(t *testing.T, p pingPayload) string {
+	t.Helper()
+	raw, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := base64.RawURLEncoding.EncodeToString(raw)
+	mac := hmac.New(sha256.New, pingSecret)
+	mac.Write([]byte(body))
+	return body + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// 排队中的请求重新打分时按到达时间校验 token：等待超过 TTL 也不会被判过期
+func TestPingTokenVerifiedAtArrivalTime(t *testing.T) {
+	old := pingSecret
+	pingSecret = []byte("test-secret")
+	defer func() { pingSecret = old }()
+
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	issued := time.Now().Add(-pingTokenTTL - 10*time.Second)
+	m := Measurement{SiteName: "s1", InstanceID: "s1-a", DelayMs: 20,
+		PingToken: signTestPingToken(t, pingPayload{InstanceID: "s1-a", IssuedAtMs: issued.UnixMilli(), Nonce: "n1", RTTMs: 20})}
+	req := AllocateRequest{ServiceID: "svc", Measurements: []Measurement{m}}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plan, err := s.planLocked(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.cands) != 0 {
+		t.Fatal("stale token accepted without arrival time")
+	}
+
+	req.arrivedAt = issued.Add(time.Second)
+	if plan, err = s.planLocked(req); err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.cands) != 1 {
+		t.Fatalf("token rejected at arrival time: %+v", plan.excluded)
+	}
+
+	// 用过之后同一 token 不能再用（nonce 保留期覆盖排队时长）
+	s.consumePingTokenLocked(m.PingToken, time.Now())
+	s.prunePingNoncesLocked(time.Now())
+	if err := s.verifyMeasurementLocked(m, req.arrivedAt); !errors.Is(err, ErrPingToken) {
+		t.Fatalf("replayed token: want ErrPingToken, got %v", err)
+	}
+}
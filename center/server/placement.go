@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// ====== placement constraints (anti-affinity / spread) ======
+// 约束基于该 client 在同一 service 上现有的 AllocationRecord，在打分前过滤候选（AvoidHeldSites / MaxPerSite 需要 ClientID）：
+// - AvoidHeldSites：不去已经持有 slot 的站点
+// - MaxPerSite：该 client 在每个站点最多持有 N 个 slot（含本次）
+// - DistinctSites：批量分配时每个站点最多一份
+
+type PlacementConstraints struct {
+	AvoidHeldSites bool `json:"avoidHeldSites,omitempty"`
+	MaxPerSite     int  `json:"maxPerSite,omitempty"`
+	DistinctSites  bool `json:"distinctSites,omitempty"`
+}
+
+type siteAllowance struct {
+	slots  int    // 本次在该站点最多还能占用的 slot 数
+	reason string // slots 不足时的排除原因
+}
+
+// siteAllowanceLocked 计算各站点的剩余额度；返回 nil 表示没有约束
+func (s *Store) siteAllowanceLocked(req AllocateRequest) map[string]siteAllowance {
+	c := req.Constraints
+	if c == nil || (!c.AvoidHeldSites && c.MaxPerSite <= 0 && !c.DistinctSites) {
+		return nil
+	}
+
+	held := map[string]int{}
+	for _, rec := range s.allocations {
+		if rec.ServiceID == req.ServiceID && rec.ClientID == req.ClientID {
+			held[rec.SiteName] += rec.gas()
+		}
+	}
+
+	out := map[string]siteAllowance{}
+	for siteName, bySvc := range s.deployments {
+		if _, ok := bySvc[req.ServiceID]; !ok {
+			continue
+		}
+		a := siteAllowance{slots: math.MaxInt}
+		if c.MaxPerSite > 0 {
+			a = siteAllowance{
+				slots:  c.MaxPerSite - held[siteName],
+				reason: fmt.Sprintf("max %d slot(s) per site (client holds %d here)", c.MaxPerSite, held[siteName]),
+			}
+		}
+		if c.DistinctSites && req.Count > 1 && req.Gas < a.slots {
+			// 批量时每站点只放一份；不写 reason：单份总是放得下
+			a.slots = req.Gas
+		}
+		if c.AvoidHeldSites && held[siteName] > 0 {
+			a = siteAllowance{slots: 0, reason: "site already held by client"}
+		}
+		out[siteName] = a
+	}
+	return out
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func newPlacementStore(t *testing.T) *Store {
+	return newTestStore(t,
+		Deployment{SiteName: "s1", Gas: 2, Cost: 1, Instances: []Instance{{InstanceID: "s1-a", Capacity: 2}}},
+		Deployment{SiteName: "s2", Gas: 2, Cost: 1, Instances: []Instance{{InstanceID: "s2-a", Capacity: 2}}},
+	)
+}
+
+// s1 总是更快，没有约束时都会选它
+func placementReq(client string, c *PlacementConstraints) AllocateRequest {
+	return AllocateRequest{ServiceID: "svc", ClientID: client, Constraints: c, Measurements: []Measurement{
+		{SiteName: "s1", InstanceID: "s1-a", DelayMs: 10},
+		{SiteName: "s2", InstanceID: "s2-a", DelayMs: 20},
+	}}
+}
+
+func (s *Store) mustAllocate(t *testing.T, req AllocateRequest) AllocateResponse {
+	t.Helper()
+	resp, err := s.Allocate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestPlacementAvoidHeldSites(t *testing.T) {
+	s := newPlacementStore(t)
+	avoid := &PlacementConstraints{AvoidHeldSites: true}
+	if got := s.mustAllocate(t, placementReq("c", avoid)).InstanceID; got != "s1-a" {
+		t.Fatalf("first allocation on %s, want s1", got)
+	}
+	if got := s.mustAllocate(t, placementReq("c", avoid)).InstanceID; got != "s2-a" {
+		t.Fatalf("second allocation on %s, want s2 (s1 already held)", got)
+	}
+	// 只看本 client 持有的站点
+	if got := s.mustAllocate(t, placementReq("d", avoid)).InstanceID; got != "s1-a" {
+		t.Fatalf("other client on %s, want s1", got)
+	}
+}
+
+func TestPlacementMaxPerSite(t *testing.T) {
+	s := newPlacementStore(t)
+	limit := &PlacementConstraints{MaxPerSite: 1}
+	s.mustAllocate(t, placementReq("c", limit))
+	if got := s.mustAllocate(t, placementReq("c", limit)).InstanceID; got != "s2-a" {
+		t.Fatalf("second allocation on %s, want s2", got)
+	}
+
+	ex, err := s.Explain(placementReq("c", limit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ex.Candidates) != 0 || len(ex.Excluded) != 2 {
+		t.Fatalf("candidates %d, excluded %+v; want every site excluded", len(ex.Candidates), ex.Excluded)
+	}
+	if _, err := s.Allocate(context.Background(), placementReq("c", limit)); !errors.Is(err, ErrNoCandidates) {
+		t.Fatalf("want ErrNoCandidates, got %v", err)
+	}
+}
+
+func TestPlacementDistinctSitesInBatch(t *testing.T) {
+	s := newPlacementStore(t)
+	req := placementReq("c", &PlacementConstraints{DistinctSites: true})
+	req.Count = 2
+	out, err := s.AllocateBatch(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].InstanceID == out[1].InstanceID {
+		t.Fatalf("batch placed on %+v, want two distinct sites", out)
+	}
+
+	// 没有约束时同一站点放得下就都放在最优站点
+	req = placementReq("c", nil)
+	req.Count = 2
+	s = newPlacementStore(t)
+	out, err = s.AllocateBatch(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[0].InstanceID != "s1-a" || out[1].InstanceID != "s1-a" {
+		t.Fatalf("unconstrained batch on %s/%s, want s1/s1", out[0].InstanceID, out[1].InstanceID)
+	}
+}
+
+// 匿名调用方共用 anonymous：依赖持有状态的约束必须带 ClientID
+func TestPlacementHeldConstraintsNeedClientID(t *testing.T) {
+	s := newPlacementStore(t)
+	for _, c := range []*PlacementConstraints{{AvoidHeldSites: true}, {MaxPerSite: 1}} {
+		if _, err := s.Allocate(context.Background(), placementReq("", c)); !errors.Is(err, ErrBadRequest) {
+			t.Errorf("%+v without ClientID: want ErrBadRequest, got %v", c, err)
+		}
+	}
+	req := placementReq("", &PlacementConstraints{DistinctSites: true})
+	req.Count = 2
+	if _, err := s.AllocateBatch(context.Background(), req); err != nil {
+		t.Fatalf("distinctSites without ClientID: %v", err)
+	}
+}
+
+// "*" 默认配额不套到匿名调用方：否则一个匿名调用方就能用光所有匿名调用方的额度
+func TestDefaultQuotaSkipsAnonymous(t *testing.T) {
+	s := newPlacementStore(t)
+	if err := s.UpsertQuota(Quota{Kind: QuotaClient, ID: "*", MaxSlots: 1, RatePerMin: 1}); err != nil {
+		t.Fatal(err)
+	}
+	s.mustAllocate(t, placementReq("", nil))
+	s.mustAllocate(t, placementReq("", nil))
+
+	s.mustAllocate(t, placementReq("c", nil))
+	if _, err := s.Allocate(context.Background(), placementReq("c", nil)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("named client over default quota: %v", err)
+	}
+
+	// 显式给 anonymous 配的仍然生效
+	if err := s.UpsertQuota(Quota{Kind: QuotaClient, ID: anonymousClient, MaxSlots: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Allocate(context.Background(), placementReq("", nil)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("anonymous over explicit quota: %v", err)
+	}
+}
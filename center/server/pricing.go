@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// ====== dynamic pricing ======
+// Deployment.Cost 是基础价；可选 Deployment.Pricing 在分配时按以下顺序叠加：
+//   - Surge：占用率超过 Threshold 后线性加价，满载时为 MaxMultiplier 倍
+//   - Schedule：命中的时段乘以 Multiplier（按 PRICING_TZ 时区，缺省本地时区；End < Start 表示跨午夜）
+//   - VolumeDiscounts：按本次请求的 slot 数（Gas × Count）取满足 MinGas 的最大折扣
+// 生效价格写进 AllocationRecord.Price，同时用于打分的 cost 维度。
+
+type PricingPolicy struct {
+	Surge           *SurgePricing `json:"surge,omitempty"`
+	Schedule        []PriceWindow `json:"schedule,omitempty"`
+	VolumeDiscounts []VolumeTier  `json:"volumeDiscounts,omitempty"`
+}
+
+type SurgePricing struct {
+	Threshold     float64 `json:"threshold"`     // 开始加价的占用率 0~1
+	MaxMultiplier float64 `json:"maxMultiplier"` // 满载时的倍数（>= 1）
+}
+
+type PriceWindow struct {
+	Start      string  `json:"start"` // "HH:MM"
+	End        string  `json:"end"`   // "HH:MM"，不含
+	Multiplier float64 `json:"multiplier"`
+}
+
+type VolumeTier struct {
+	MinGas   int     `json:"minGas"`
+	Discount float64 `json:"discount"` // 0~1，0.1 = 九折
+}
+
+func pricingLocation() *time.Location {
+	if tz := os.Getenv("PRICING_TZ"); tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+var pricingTZ = pricingLocation()
+
+func parseClock(s string) (int, bool) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// validatePricing 在登记部署时校验策略
+func validatePricing(p *PricingPolicy) error {
+	if p == nil {
+		return nil
+	}
+	if sp := p.Surge; sp != nil {
+		if sp.Threshold < 0 || sp.Threshold >= 1 {
+			return fmt.Errorf("%w: surge.threshold must be in [0,1)", ErrBadRequest)
+		}
+		if sp.MaxMultiplier < 1 {
+			return fmt.Errorf("%w: surge.maxMultiplier must be >= 1", ErrBadRequest)
+		}
+	}
+	for i, w := range p.Schedule {
+		_, ok1 := parseClock(w.Start)
+		_, ok2 := parseClock(w.End)
+		if !ok1 || !ok2 {
+			return fmt.Errorf("%w: schedule[%d] start/end must be HH:MM", ErrBadRequest, i)
+		}
+		if w.Multiplier <= 0 {
+			return fmt.Errorf("%w: schedule[%d].multiplier must be > 0", ErrBadRequest, i)
+		}
+	}
+	for i, v := range p.VolumeDiscounts {
+		if v.MinGas < 1 || v.Discount < 0 || v.Discount >= 1 {
+			return fmt.Errorf("%w: volumeDiscounts[%d] needs minGas >= 1 and discount in [0,1)", ErrBadRequest, i)
+		}
+	}
+	return nil
+}
+
+func (sp *SurgePricing) multiplier(util float64) float64 {
+	if sp == nil || util <= sp.Threshold {
+		return 1
+	}
+	frac := math.Min((util-sp.Threshold)/(1-sp.Threshold), 1)
+	return 1 + (sp.MaxMultiplier-1)*frac
+}
+
+func (w PriceWindow) contains(minute int) bool {
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end // 跨午夜
+}
+
+// effectivePrice 计算单个 slot 的生效价格（保留两位小数）；volume 为本次请求的 slot 总数
+func effectivePrice(dep Deployment, util float64, volume int, now time.Time) float64 {
+	price := float64(dep.Cost)
+	if p := dep.Pricing; p != nil {
+		price *= p.Surge.multiplier(util)
+
+		local := now.In(pricingTZ)
+		minute := local.Hour()*60 + local.Minute()
+		for _, w := range p.Schedule {
+			if w.contains(minute) {
+				price *= w.Multiplier
+				break
+			}
+		}
+
+		discount, best := 0.0, 0
+		for _, v := range p.VolumeDiscounts {
+			if volume >= v.MinGas && v.MinGas > best {
+				discount, best = v.Discount, v.MinGas
+			}
+		}
+		price *= 1 - discount
+	}
+	return math.Round(price*100) / 100
+}
+
+// priceOf 当前单 slot 价格（展示用：volume = 1）
+func (st *DeploymentState) priceOf(now time.Time) float64 {
+	return effectivePrice(st.Deployment, st.utilization(), 1, now)
+}
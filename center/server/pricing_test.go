@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func at(hh, mm int) time.Time { return time.Date(2026, 1, 5, hh, mm, 0, 0, pricingTZ) }
+
+func TestEffectivePriceSurge(t *testing.T) {
+	dep := Deployment{Cost: 10, Pricing: &PricingPolicy{Surge: &SurgePricing{Threshold: 0.5, MaxMultiplier: 3}}}
+	for util, want := range map[float64]float64{0: 10, 0.5: 10, 0.75: 20, 1: 30} {
+		if got := effectivePrice(dep, util, 1, at(12, 0)); got != want {
+			t.Errorf("util %.2f: price %v, want %v", util, got, want)
+		}
+	}
+}
+
+func TestEffectivePriceSchedule(t *testing.T) {
+	dep := Deployment{Cost: 10, Pricing: &PricingPolicy{Schedule: []PriceWindow{
+		{Start: "22:00", End: "06:00", Multiplier: 0.5}, // 跨午夜
+		{Start: "09:00", End: "18:00", Multiplier: 1.5},
+	}}}
+	for _, tc := range []struct {
+		hh, mm int
+		want   float64
+	}{{23, 30, 5}, {5, 59, 5}, {6, 0, 10}, {9, 0, 15}, {17, 59, 15}, {18, 0, 10}} {
+		if got := effectivePrice(dep, 0, 1, at(tc.hh, tc.mm)); got != tc.want {
+			t.Errorf("%02d:%02d: price %v, want %v", tc.hh, tc.mm, got, tc.want)
+		}
+	}
+}
+
+func TestEffectivePriceVolumeAndStacking(t *testing.T) {
+	dep := Deployment{Cost: 10, Pricing: &PricingPolicy{
+		Surge:           &SurgePricing{Threshold: 0, MaxMultiplier: 2},
+		Schedule:        []PriceWindow{{Start: "00:00", End: "12:00", Multiplier: 1.5}},
+		VolumeDiscounts: []VolumeTier{{MinGas: 4, Discount: 0.1}, {MinGas: 2, Discount: 0.05}},
+	}}
+	// 取满足 MinGas 的最大档，而不是列表里第一个满足的
+	for volume, want := range map[int]float64{1: 10, 2: 9.5, 3: 9.5, 8: 9} {
+		if got := effectivePrice(dep, 0, volume, at(13, 0)); got != want {
+			t.Errorf("volume %d: price %v, want %v", volume, got, want)
+		}
+	}
+	// 10 × surge 1.5（半载）× 时段 1.5 × 九折
+	if got := effectivePrice(dep, 0.5, 4, at(8, 0)); got != 20.25 {
+		t.Errorf("stacked: price %v, want 20.25", got)
+	}
+}
+
+func TestValidatePricing(t *testing.T) {
+	for _, p := range []*PricingPolicy{
+		{Surge: &SurgePricing{Threshold: 1, MaxMultiplier: 2}},
+		{Surge: &SurgePricing{Threshold: 0.5, MaxMultiplier: 0.5}},
+		{Schedule: []PriceWindow{{Start: "25:00", End: "06:00", Multiplier: 1}}},
+		{Schedule: []PriceWindow{{Start: "01:00", End: "06:00", Multiplier: 0}}},
+		{VolumeDiscounts: []VolumeTier{{MinGas: 2, Discount: 1}}},
+	} {
+		if err := validatePricing(p); !errors.Is(err, ErrBadRequest) {
+			t.Errorf("%+v: want ErrBadRequest, got %v", p, err)
+		}
+	}
+}
+
+// 分配价格随占用率上涨，并写进 allocation
+func TestAllocationPriceFollowsUtilization(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 2, Cost: 10,
+		Instances: []Instance{{InstanceID: "s1-a", Capacity: 2}},
+		Pricing:   &PricingPolicy{Surge: &SurgePricing{Threshold: 0, MaxMultiplier: 3}}})
+	req := AllocateRequest{ServiceID: "svc"}
+	if p := s.mustAllocate(t, req).Price; p != 10 {
+		t.Fatalf("first price %v, want 10", p)
+	}
+	if p := s.mustAllocate(t, req).Price; p != 20 {
+		t.Fatalf("second price %v, want 20 (half full)", p)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ====== priority classes & preemption ======
+// batch < standard < interactive。
+// - 等待队列按优先级排序，同级 FIFO
+// - service 开启 Preemption 时，高优先级请求没有候选会抢占同 service 低优先级的 allocation：
+//   被抢占的记录移入 s.revoked（renew 返回 410，可通过 /api/allocations/{id} 查询），并回调 NotifyURL
+// - service.GuaranteedGas 为保底容量，batch 请求不能占用
+
+const (
+	PriorityBatch       = "batch"
+	PriorityStandard    = "standard"
+	PriorityInteractive = "interactive"
+
+	revokedRetention = 10 * time.Minute // 被抢占记录保留多久
+)
+
+var ErrRevoked = errors.New("allocation revoked")
+
+func priorityRank(p string) (int, error) {
+	switch p {
+	case PriorityBatch:
+		return 0, nil
+	case "", PriorityStandard:
+		return 1, nil
+	case PriorityInteractive:
+		return 2, nil
+	}
+	return 0, fmt.Errorf("%w: unknown Priority %q", ErrBadRequest, p)
+}
+
+func rankOf(p string) int {
+	r, _ := priorityRank(p)
+	return r
+}
+
+// bestEffortFitsLocked：分配 gas 个 slot 后，service 剩余空闲是否仍 >= GuaranteedGas
+func (s *Store) bestEffortFitsLocked(svc Service, gas int) bool {
+	if svc.GuaranteedGas <= 0 {
+		return true
+	}
+	free := 0
+	for _, bySvc := range s.deployments {
+		if st, ok := bySvc[svc.ServiceID]; ok {
+			free += st.GasAvailable
+		}
+	}
+	return free-gas >= svc.GuaranteedGas
+}
+
+// preemptLocked 为 plan 腾出一个实例：只在 plan.gasShort（仅因 slot 不够被排除）的实例里，
+// 找"撤掉最少低优先级 allocation 即可满足"的那个，撤掉后返回 true；
+// 因断路器、未测量、放置约束、预算等被排除的实例撤了也用不上，不参与。
+// service 未开启抢占、找不到可行方案，或是 reserve（还没确认的报价不能撤掉别人正在用的 allocation）返回 false
+func (s *Store) preemptLocked(plan *allocPlan) bool {
+	if !plan.svc.Preemption || plan.req.reserve {
+		return false
+	}
+	myRank := rankOf(plan.req.Priority)
+	need := plan.req.Gas
+
+	var best []string
+	for _, ex := range plan.gasShort {
+		st := s.deployments[ex.SiteName][plan.req.ServiceID]
+		if st == nil {
+			continue
+		}
+		inst := st.instance(ex.InstanceID)
+		if inst == nil || inst.Capacity < need {
+			continue
+		}
+
+		// 该实例上可抢占的 allocation：优先级最低的先撤，同级撤最新的
+		var victims []string
+		for aid, rec := range s.allocations {
+			if rec.ServiceID == plan.req.ServiceID && rec.SiteName == ex.SiteName &&
+				rec.InstanceID == ex.InstanceID && rankOf(rec.Priority) < myRank {
+				victims = append(victims, aid)
+			}
+		}
+		sort.Slice(victims, func(i, j int) bool {
+			a, b := s.allocations[victims[i]], s.allocations[victims[j]]
+			if ra, rb := rankOf(a.Priority), rankOf(b.Priority); ra != rb {
+				return ra < rb
+			}
+			return a.AllocatedAt.After(b.AllocatedAt)
+		})
+
+		free := inst.Available
+		var chosen []string
+		for _, aid := range victims {
+			if free >= need {
+				break
+			}
+			free += s.allocations[aid].gas()
+			chosen = append(chosen, aid)
+		}
+		if free < need || len(chosen) == 0 {
+			continue
+		}
+		if best == nil || len(chosen) < len(best) {
+			best = chosen
+		}
+	}
+	if best == nil {
+		return false
+	}
+
+	reason := fmt.Sprintf("preempted by %s request", plan.req.Priority)
+	for _, aid := range best {
+		s.revokeLocked(aid, reason)
+	}
+	return true
+}
+
+// revokeLocked 撤销 allocation：slot 直接留给抢占者（不唤醒等待队列），记录转入 s.revoked 并异步通知 owner
+func (s *Store) revokeLocked(allocationID, reason string) {
+	rec, ok := s.dropAllocationLocked(allocationID)
+	if !ok {
+		return
+	}
+	rec.RevokedAt = time.Now()
+	rec.RevokeReason = reason
+	s.revoked[allocationID] = rec
+	s.endAllocationLocked(allocationID, rec, EndRevoked, rec.RevokedAt)
+	log.Printf("allocation %s revoked: %s", allocationID, reason)
+
+	if rec.NotifyURL != "" {
+		go notifyRevoked(rec.NotifyURL, RevokeNotice{
+			AllocationID: allocationID,
+			ServiceID:    rec.ServiceID,
+			InstanceID:   rec.InstanceID,
+			Reason:       reason,
+			RevokedAt:    rec.RevokedAt,
+		})
+	}
+}
+
+// ---- revoke notify ----
+// NotifyURL 由 client 提供、center 主动 POST，需防 SSRF：
+// - 只允许 http / https，不允许 userinfo，不跟随重定向
+// - 配置了 NOTIFY_ALLOWED_HOSTS（逗号分隔主机名）时只允许这些主机（运维已审核，可以是内网地址）
+// - 未配置时允许任意主机名，但连接时拒绝回环、私网、链路本地等非公网地址（按解析后的 IP 判断，防 DNS rebinding）
+
+var notifyAllowedHosts = parseHostList(os.Getenv("NOTIFY_ALLOWED_HOSTS"))
+
+func parseHostList(v string) map[string]bool {
+	out := map[string]bool{}
+	for _, h := range strings.Split(v, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			out[h] = true
+		}
+	}
+	return out
+}
+
+func validateNotifyURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("%w: NotifyURL must be an absolute http(s) URL without credentials", ErrBadRequest)
+	}
+	if len(notifyAllowedHosts) > 0 && !notifyAllowedHosts[strings.ToLower(u.Hostname())] {
+		return fmt.Errorf("%w: NotifyURL host %q not in NOTIFY_ALLOWED_HOSTS", ErrBadRequest, u.Hostname())
+	}
+	return nil
+}
+
+func publicIP(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+var notifyClient = &http.Client{
+	Timeout: 3 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 3 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				if len(notifyAllowedHosts) > 0 {
+					return nil // 主机名已在 validateNotifyURL 里按白名单校验
+				}
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if !publicIP(net.ParseIP(host)) {
+					return fmt.Errorf("notify to non-public address %s refused", host)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+func notifyRevoked(url string, n RevokeNotice) {
+	b, _ := json.Marshal(n)
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		log.Printf("revoke notify %s failed: %v", url, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// pruneRevokedLocked 清掉保留期已过的被抢占记录
+func (s *Store) pruneRevokedLocked(now time.Time) {
+	for aid, rec := range s.revoked {
+		if now.Sub(rec.RevokedAt) > revokedRetention {
+			s.settleRevokedLocked(aid)
+		}
+	}
+}
+
+// AllocationStatus 查询 allocation 当前状态（active / revoked）
+func (s *Store) AllocationStatus(allocationID string) (AllocationStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.allocations[allocationID]; ok {
+		state := "active"
+		if rec.Reserved {
+			state = "reserved"
+		}
+		return AllocationStatus{AllocationID: allocationID, State: state, Record: rec}, true
+	}
+	if rec, ok := s.revoked[allocationID]; ok {
+		return AllocationStatus{AllocationID: allocationID, State: "revoked", Record: rec}, true
+	}
+	return AllocationStatus{}, false
+}
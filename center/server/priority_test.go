@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newPreemptStore(t *testing.T) (*Store, AllocateResponse) {
+	t.Helper()
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	if err := s.UpsertService(Service{ServiceID: "svc", Preemption: true}); err != nil {
+		t.Fatal(err)
+	}
+	victim, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc", ClientID: "low", Priority: PriorityBatch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, victim
+}
+
+func TestPreemptTakesSlotForHigherPriority(t *testing.T) {
+	s, victim := newPreemptStore(t)
+	if _, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc", ClientID: "high", Priority: PriorityInteractive}); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := s.AllocationStatus(victim.AllocationID); st.State != "revoked" {
+		t.Fatalf("victim state = %q, want revoked", st.State)
+	}
+}
+
+// 实例因断路器打开被排除时，撤掉它上面的 allocation 也用不上，不能抢占
+func TestPreemptSkipsInstancesExcludedForOtherReasons(t *testing.T) {
+	s, victim := newPreemptStore(t)
+	s.mu.Lock()
+	for i := 0; i < 10; i++ {
+		s.recordOutcomeLocked("s1-a", "", false, "invocation", "boom", time.Now())
+	}
+	s.mu.Unlock()
+
+	_, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc", ClientID: "high", Priority: PriorityInteractive})
+	if !errors.Is(err, ErrNoCandidates) {
+		t.Fatalf("want ErrNoCandidates, got %v", err)
+	}
+	if st, _ := s.AllocationStatus(victim.AllocationID); st.State != "active" {
+		t.Fatalf("victim state = %q, want active", st.State)
+	}
+}
+
+func TestValidateNotifyURL(t *testing.T) {
+	for _, u := range []string{"ftp://example.com/x", "/relative", "http://user:pw@example.com/"} {
+		if err := validateNotifyURL(u); !errors.Is(err, ErrBadRequest) {
+			t.Errorf("%s: want ErrBadRequest, got %v", u, err)
+		}
+	}
+	if err := validateNotifyURL("https://example.com/hook"); err != nil {
+		t.Errorf("https URL rejected: %v", err)
+	}
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "169.254.169.254", "::1"} {
+		if publicIP(net.ParseIP(ip)) {
+			t.Errorf("%s treated as public", ip)
+		}
+	}
+}
+
+// 超配额的请求在抢占之前就被拒，不能先撤掉别人的 allocation
+func TestPreemptNotTriggeredByOverQuotaRequest(t *testing.T) {
+	s, victim := newPreemptStore(t)
+	if err := s.UpsertQuota(Quota{Kind: QuotaClient, ID: "greedy", MaxSlots: 0, RatePerMin: 1}); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.bucketLocked(quotaKey(QuotaClient, "greedy")).take(s.quotas[quotaKey(QuotaClient, "greedy")], 1, time.Now())
+	s.mu.Unlock()
+
+	_, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc", ClientID: "greedy", Priority: PriorityInteractive})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("want ErrQuotaExceeded, got %v", err)
+	}
+	if st, _ := s.AllocationStatus(victim.AllocationID); st.State != "active" {
+		t.Fatalf("victim state = %q, want active", st.State)
+	}
+}
+
+// 抢占目标超出剩余预算时不抢占
+func TestPreemptNotTriggeredByOverBudgetRequest(t *testing.T) {
+	s, victim := newPreemptStore(t)
+	if err := s.UpsertBudget(Budget{Kind: QuotaClient, ID: "poor", Limit: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc", ClientID: "poor", Priority: PriorityInteractive})
+	if err == nil {
+		t.Fatal("over-budget request allocated")
+	}
+	if st, _ := s.AllocationStatus(victim.AllocationID); st.State != "active" {
+		t.Fatalf("victim state = %q, want active (err %v)", st.State, err)
+	}
+}
+
+// 抢占后重新打分选中的候选同样要过预算检查（这里用一个把价格抬高的策略模拟重新打分后价格变化）
+func TestPreemptRechecksBudgetOnReplan(t *testing.T) {
+	RegisterScorer("test-reprice", ScorerFunc(func(cands []scored, req AllocateRequest) {
+		for i := range cands {
+			cands[i].price = 100
+		}
+	}))
+	s, victim := newPreemptStore(t)
+	if err := s.UpsertBudget(Budget{Kind: QuotaClient, ID: "high", Limit: 10}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc", ClientID: "high", Priority: PriorityInteractive, Strategy: "test-reprice"})
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("want ErrBudgetExceeded, got %v", err)
+	}
+	if st, _ := s.AllocationStatus(victim.AllocationID); st.State != "revoked" {
+		t.Fatalf("victim state = %q, want revoked", st.State)
+	}
+	if got := s.available(t, "s1", "s1-a"); got != 1 {
+		t.Fatalf("available = %d, want the revoked slot back", got)
+	}
+}
+
+// 还没 commit 的 reservation 不能抢占别人正在用的 allocation
+func TestReserveDoesNotPreempt(t *testing.T) {
+	s, victim := newPreemptStore(t)
+	_, err := s.Reserve(context.Background(), AllocateRequest{ServiceID: "svc", ClientID: "high", Priority: PriorityInteractive})
+	if !errors.Is(err, ErrNoCandidates) {
+		t.Fatalf("want ErrNoCandidates, got %v", err)
+	}
+	if st, _ := s.AllocationStatus(victim.AllocationID); st.State != "active" {
+		t.Fatalf("victim state = %q, want active", st.State)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====== center-side active probing ======
+// center 周期性 GET 每个实例的 /ping，记录 RTT 与可达性；结果同时喂给实例健康状态/断路器（见 health.go）。
+// 打分时把 server 侧 RTT 与 client 上报的 delay 按 ServerDelayBlend 混合；client 没测（兜底 delay=0）时直接用 server 侧 RTT。
+//
+// 探测地址：Instance.ProbeAddr > 绝对地址的 Addr > PROBE_BASE_URL + 相对 Addr（如 http://client + /site2-a）；
+// 都没有的实例不探测。
+
+const (
+	defaultProbeEvery   = 10 * time.Second
+	defaultProbeTimeout = 2 * time.Second
+	defaultServerBlend  = 0.5
+)
+
+type ProbeState struct {
+	ProbeURL            string       `json:"probeUrl"`
+	Reachable           bool         `json:"reachable"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	LastProbeAt         time.Time    `json:"lastProbeAt"`
+	LastRTTMs           int          `json:"lastRttMs"`
+	LastError           string       `json:"lastError,omitempty"`
+	RTT                 DelayHistory `json:"rtt"`
+}
+
+type probeTarget struct {
+	instanceID string
+	url        string
+}
+
+// probeURL 解析实例的探测地址；返回空串表示无法探测
+func probeURL(inst Instance, base string) string {
+	addr := inst.ProbeAddr
+	if addr == "" {
+		addr = inst.Addr
+	}
+	if addr == "" {
+		return ""
+	}
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		if base == "" {
+			return ""
+		}
+		addr = strings.TrimRight(base, "/") + "/" + strings.TrimLeft(addr, "/")
+	}
+	return strings.TrimRight(addr, "/") + "/ping"
+}
+
+func serverBlendFromEnv() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("PROBE_BLEND"), 64); err == nil && v >= 0 && v <= 1 {
+		return v
+	}
+	return defaultServerBlend
+}
+
+var serverBlend = serverBlendFromEnv()
+
+// probeTargetsLocked 列出当前所有可探测的实例（去重）
+func (s *Store) probeTargetsLocked(base string) []probeTarget {
+	seen := map[string]bool{}
+	var out []probeTarget
+	for _, bySvc := range s.deployments {
+		for _, st := range bySvc {
+			for _, inst := range st.Deployment.Instances {
+				if seen[inst.InstanceID] {
+					continue
+				}
+				seen[inst.InstanceID] = true
+				if u := probeURL(inst, base); u != "" {
+					out = append(out, probeTarget{instanceID: inst.InstanceID, url: u})
+				}
+			}
+		}
+	}
+	return out
+}
+
+// recordProbeLocked 记录一次探测结果，并作为健康信号推进断路器（与调用结果分开计数，见 health.go）
+func (s *Store) recordProbeLocked(t probeTarget, rtt time.Duration, err error, now time.Time) {
+	ps := s.probes[t.instanceID]
+	if ps == nil {
+		ps = &ProbeState{}
+		s.probes[t.instanceID] = ps
+	}
+	ps.ProbeURL = t.url
+	ps.LastProbeAt = now
+	if err != nil {
+		ps.Reachable = false
+		ps.ConsecutiveFailures++
+		ps.LastError = err.Error()
+		s.recordProbeOutcomeLocked(t.instanceID, false, ps.LastError, now)
+		return
+	}
+	ps.Reachable = true
+	ps.ConsecutiveFailures = 0
+	ps.LastError = ""
+	ps.LastRTTMs = int(rtt.Milliseconds())
+	ps.RTT.add(DelaySample{At: now, DelayMs: ps.LastRTTMs})
+	s.recordProbeOutcomeLocked(t.instanceID, true, "", now)
+}
+
+// serverDelayLocked 返回 server 侧探测的平滑 RTT（EWMA）；没有新鲜的成功探测返回 false
+func (s *Store) serverDelayLocked(instanceID string) (int, bool) {
+	ps := s.probes[instanceID]
+	if ps == nil || !ps.Reachable || ps.RTT.Count == 0 {
+		return 0, false
+	}
+	if time.Since(ps.LastProbeAt) > 3*s.probeEvery {
+		return 0, false
+	}
+	return int(math.Round(ps.RTT.EWMA)), true
+}
+
+// blendDelay 混合 client 与 server 侧 delay：synthetic（client 没测）时直接用 server 侧
+func blendDelay(clientMs, serverMs int, synthetic bool, svc Service) int {
+	if synthetic {
+		return serverMs
+	}
+	beta := serverBlend
+	if svc.ServerDelayBlend > 0 {
+		beta = math.Min(svc.ServerDelayBlend, 1)
+	}
+	return int(math.Round((1-beta)*float64(clientMs) + beta*float64(serverMs)))
+}
+
+func (s *Store) ProbeStateOf(instanceID string) (ProbeState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps := s.probes[instanceID]
+	if ps == nil {
+		return ProbeState{}, false
+	}
+	out := *ps
+	out.RTT.Samples = ps.RTT.ordered()
+	return out, true
+}
+
+// runProber 后台周期探测；PROBE_INTERVAL_SEC 控制周期
+func runProber(s *Store, base string) {
+	client := &http.Client{Timeout: defaultProbeTimeout}
+	t := time.NewTicker(s.probeEvery)
+	defer t.Stop()
+	for range t.C {
+		s.mu.Lock()
+		targets := s.probeTargetsLocked(base)
+		s.mu.Unlock()
+		if len(targets) == 0 {
+			continue
+		}
+
+		type result struct {
+			t   probeTarget
+			rtt time.Duration
+			err error
+		}
+		results := make([]result, len(targets))
+		var wg sync.WaitGroup
+		for i, tg := range targets {
+			wg.Add(1)
+			go func(i int, tg probeTarget) {
+				defer wg.Done()
+				rtt, err := probeOnce(client, tg.url)
+				results[i] = result{tg, rtt, err}
+			}(i, tg)
+		}
+		wg.Wait()
+
+		now := time.Now()
+		s.mu.Lock()
+		for _, r := range results {
+			s.recordProbeLocked(r.t, r.rtt, r.err, now)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func probeOnce(client *http.Client, url string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	t0 := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return 0, &probeStatusError{resp.StatusCode}
+	}
+	return time.Since(t0), nil
+}
+
+type probeStatusError struct{ code int }
+
+func (e *probeStatusError) Error() string { return "ping HTTP " + strconv.Itoa(e.code) }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProbeURL(t *testing.T) {
+	for _, tc := range []struct {
+		inst Instance
+		base string
+		want string
+	}{
+		{Instance{ProbeAddr: "http://gw:8080/", Addr: "/s1-a"}, "http://client", "http://gw:8080/ping"},
+		{Instance{Addr: "https://s1.example/a"}, "", "https://s1.example/a/ping"},
+		{Instance{Addr: "/s1-a"}, "http://client/", "http://client/s1-a/ping"},
+		{Instance{Addr: "/s1-a"}, "", ""},
+	} {
+		if got := probeURL(tc.inst, tc.base); got != tc.want {
+			t.Errorf("probeURL(%+v, %q) = %q, want %q", tc.inst, tc.base, got, tc.want)
+		}
+	}
+}
+
+func TestBlendDelay(t *testing.T) {
+	if got := blendDelay(0, 40, true, Service{}); got != 40 {
+		t.Errorf("synthetic: %d, want server RTT 40", got)
+	}
+	want := int(float64(100)*(1-serverBlend) + float64(20)*serverBlend + 0.5)
+	if got := blendDelay(100, 20, false, Service{}); got != want {
+		t.Errorf("default blend: %d, want %d", got, want)
+	}
+	if got := blendDelay(100, 20, false, Service{ServerDelayBlend: 0.25}); got != 80 {
+		t.Errorf("service blend 0.25: %d, want 80", got)
+	}
+}
+
+// 打分用的 delay 混入新鲜的成功探测；探测失败或过期后只用 client 测量
+func TestProbeDelayBlendsIntoScore(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 1, Cost: 1})
+	s.services["svc"] = Service{ServiceID: "svc", ServerDelayBlend: 0.5}
+	req := AllocateRequest{ServiceID: "svc", Measurements: []Measurement{{SiteName: "s1", InstanceID: "s1-a", DelayMs: 100}}}
+	target := probeTarget{instanceID: "s1-a", url: "http://gw/s1-a/ping"}
+	scored := func() ScoredCandidate {
+		t.Helper()
+		ex, err := s.Explain(req)
+		if err != nil || ex.Chosen == nil {
+			t.Fatalf("explain: %+v, %v", ex, err)
+		}
+		return *ex.Chosen
+	}
+
+	s.mu.Lock()
+	s.recordProbeLocked(target, 20*time.Millisecond, nil, time.Now())
+	s.mu.Unlock()
+	if c := scored(); c.DelayMs != 60 || c.ServerDelayMs != 20 || c.MeasuredDelayMs != 100 {
+		t.Fatalf("fresh probe: delay %d, server %d, measured %d; want 60/20/100", c.DelayMs, c.ServerDelayMs, c.MeasuredDelayMs)
+	}
+
+	s.mu.Lock()
+	s.recordProbeLocked(target, 0, errors.New("timeout"), time.Now())
+	s.mu.Unlock()
+	if c := scored(); c.DelayMs != 100 || c.ServerDelayMs != -1 {
+		t.Fatalf("failed probe: delay %d, server %d; want 100/-1", c.DelayMs, c.ServerDelayMs)
+	}
+
+	s.mu.Lock()
+	s.recordProbeLocked(target, 20*time.Millisecond, nil, time.Now().Add(-4*s.probeEvery))
+	s.mu.Unlock()
+	if c := scored(); c.DelayMs != 100 {
+		t.Fatalf("stale probe: delay %d, want 100", c.DelayMs)
+	}
+}
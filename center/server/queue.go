@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ====== wait-for-capacity queue ======
+// 请求带 WaitTimeoutMs 且当前没有可用容量时，进入该 service 的队列（按优先级排序，同级 FIFO）；
+// Release / 新部署归还容量时在同一把锁内按队列顺序尝试每个等待者：排在前面的满足不了（如 Gas 较大），
+// 后面能满足的照样先拿，不让一个大请求堵住整个队列。新请求总是先直接尝试：
+// 每次有容量归还都会先服务队列，剩下的空闲容量是队列里没人用得上的。
+// 按现有部署的实例容量永远满足不了的请求不排队，直接返回 ErrNeverFits。
+// HTTP client 断开（ctx 取消）时出队；若恰好已被分配则立即归还。
+
+const maxWaitTimeout = 5 * time.Minute
+
+var (
+	ErrWaitTimeout = errors.New("timed out waiting for capacity")
+	ErrNeverFits   = errors.New("request exceeds deployed instance capacity")
+)
+
+type waiter struct {
+	id         string
+	serviceID  string
+	clientID   string
+	priority   string
+	rank       int
+	enqueuedAt time.Time
+	try        func() (any, error) // 锁内尝试完成；返回容量类错误表示继续等
+	done       chan waitResult     // 缓冲 1，serveWaitersLocked 写入
+}
+
+type waitResult struct {
+	v   any
+	err error
+}
+
+// isCapacityErr：只有"暂时没容量"才值得排队，参数错误等直接返回
+func isCapacityErr(err error) bool {
+	return errors.Is(err, ErrNoCandidates) || errors.Is(err, ErrInsufficientGas)
+}
+
+func (s *Store) allocateOrWait(ctx context.Context, req AllocateRequest, try func() (any, error)) (any, error) {
+	if req.WaitTimeoutMs < 0 {
+		return nil, fmt.Errorf("%w: WaitTimeoutMs must be >= 0", ErrBadRequest)
+	}
+	timeout := time.Duration(req.WaitTimeoutMs) * time.Millisecond
+	if timeout > maxWaitTimeout {
+		timeout = maxWaitTimeout
+	}
+
+	rank, err := priorityRank(req.Priority)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	v, err := try()
+	if err == nil || timeout <= 0 || !isCapacityErr(err) {
+		s.mu.Unlock()
+		return v, err
+	}
+	// 等了也没用的请求不进队列
+	if err := s.neverFitsLocked(req); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	w := &waiter{
+		id:         req.WaitID,
+		serviceID:  req.ServiceID,
+		clientID:   req.ClientID,
+		priority:   req.Priority,
+		rank:       rank,
+		enqueuedAt: time.Now(),
+		try:        try,
+		done:       make(chan waitResult, 1),
+	}
+	if w.id == "" {
+		w.id = newID("wait")
+	}
+	s.enqueueLocked(w)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-w.done:
+		return r.v, r.err
+	case <-timer.C:
+		if r, ok := s.cancelWaiter(w, false); ok {
+			return r.v, r.err // 超时的同一刻被满足了：照常返回
+		}
+		return nil, ErrWaitTimeout
+	case <-ctx.Done():
+		s.cancelWaiter(w, true)
+		return nil, ctx.Err()
+	}
+}
+
+// cancelWaiter 把 w 移出队列。若 w 已被满足（出队和分配发生在取消之前），
+// 返回其结果；rollback=true 时（client 已断开）把已分配的 slot 立即还回去。
+func (s *Store) cancelWaiter(w *waiter, rollback bool) (waitResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := s.waiters[w.serviceID]
+	for i, x := range q {
+		if x == w {
+			s.waiters[w.serviceID] = append(q[:i:i], q[i+1:]...)
+			if len(s.waiters[w.serviceID]) == 0 {
+				delete(s.waiters, w.serviceID)
+			}
+			// 队首离开后，后面的请求可能就能满足了
+			s.serveWaitersLocked(w.serviceID)
+			return waitResult{}, false
+		}
+	}
+
+	r := <-w.done
+	if rollback && r.err == nil {
+		for _, resp := range allocationsOf(r.v) {
+			_ = s.releaseLocked(resp.AllocationID, EndCancelled)
+		}
+	}
+	return r, true
+}
+
+// enqueueLocked 插到最后一个同级或更高优先级等待者之后
+func (s *Store) enqueueLocked(w *waiter) {
+	q := s.waiters[w.serviceID]
+	pos := len(q)
+	for pos > 0 && q[pos-1].rank < w.rank {
+		pos--
+	}
+	q = append(q, nil)
+	copy(q[pos+1:], q[pos:])
+	q[pos] = w
+	s.waiters[w.serviceID] = q
+}
+
+// neverFitsLocked：按该 service 现有部署的实例容量（不看当前占用），req 的 Count 份 × Gas 能否放下。
+// 还没有任何部署时不判定（之后可能有新部署）
+func (s *Store) neverFitsLocked(req AllocateRequest) error {
+	gas, count := max(req.Gas, 1), max(req.Count, 1)
+	deployed, fit, largest := false, 0, 0
+	for _, bySvc := range s.deployments {
+		st, ok := bySvc[req.ServiceID]
+		if !ok {
+			continue
+		}
+		deployed = true
+		for _, inst := range st.Deployment.Instances {
+			fit += inst.Capacity / gas
+			largest = max(largest, inst.Capacity)
+		}
+	}
+	if !deployed || fit >= count {
+		return nil
+	}
+	return fmt.Errorf("%w: need %d × Gas %d, largest instance capacity %d", ErrNeverFits, count, gas, largest)
+}
+
+func allocationsOf(v any) []AllocateResponse {
+	switch x := v.(type) {
+	case AllocateResponse:
+		return []AllocateResponse{x}
+	case []AllocateResponse:
+		return x
+	}
+	return nil
+}
+
+// serveWaitersLocked 按队列顺序尝试完成等待者：满足不了的留在原位，继续试后面的，
+// 直到一轮下来没有人能完成；调用方需持有 s.mu
+func (s *Store) serveWaitersLocked(serviceID string) {
+	if _, ok := s.serving[serviceID]; ok {
+		// 等待者的 try 里又归还了 slot（抢占后没用上、batch 回滚等）：外层循环会再扫一轮，这里不重入
+		s.serving[serviceID] = true
+		return
+	}
+	s.serving[serviceID] = false
+	defer delete(s.serving, serviceID)
+
+	fair := s.services[serviceID].FairShare
+	for {
+		if s.serveOneWaiterLocked(serviceID, fair) {
+			continue
+		}
+		if !s.serving[serviceID] {
+			break
+		}
+		s.serving[serviceID] = false
+	}
+	if len(s.waiters[serviceID]) == 0 {
+		delete(s.waiters, serviceID)
+	}
+}
+
+// serveOneWaiterLocked 完成队列里第一个能完成的等待者（fair-share 时最公平的优先），返回是否有人出队
+func (s *Store) serveOneWaiterLocked(serviceID string, fair bool) bool {
+	q := s.waiters[serviceID]
+	order := make([]int, 0, len(q))
+	first := -1
+	if fair && len(q) > 0 {
+		first = s.fairestWaiterLocked(q)
+		order = append(order, first)
+	}
+	for i := range q {
+		if i != first {
+			order = append(order, i)
+		}
+	}
+	for _, i := range order {
+		w := q[i]
+		v, err := w.try()
+		if err != nil && isCapacityErr(err) {
+			continue
+		}
+		s.waiters[serviceID] = append(q[:i:i], q[i+1:]...)
+		w.done <- waitResult{v: v, err: err}
+		return true
+	}
+	return false
+}
+
+// fairestWaiterLocked（fair-share 模式）：在最高优先级的等待者里，
+// 选其 client 当前在该 service 持有 slot 最少的（同数量按排队先后）
+func (s *Store) fairestWaiterLocked(q []*waiter) int {
+	best, bestHeld := 0, -1
+	for i, w := range q {
+		if w.rank < q[0].rank {
+			break
+		}
+		held := s.heldByLocked(QuotaClient, w.clientID, w.serviceID)
+		if bestHeld < 0 || held < bestHeld {
+			best, bestHeld = i, held
+		}
+	}
+	return best
+}
+
+// ---- queue view ----
+
+// QueueStatus 列出排队情况；serviceID 为空则列出全部
+func (s *Store) QueueStatus(serviceID string) []QueueEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	out := []QueueEntry{}
+	for sid, q := range s.waiters {
+		if serviceID != "" && sid != serviceID {
+			continue
+		}
+		for i, w := range q {
+			out = append(out, QueueEntry{
+				WaitID:    w.id,
+				ServiceID: sid,
+				Priority:  w.priority,
+				Position:  i + 1,
+				WaitingMs: now.Sub(w.enqueuedAt).Milliseconds(),
+			})
+		}
+	}
+	return out
+}
+
+func (s *Store) QueuePosition(waitID string) (QueueEntry, bool) {
+	for _, e := range s.QueueStatus("") {
+		if e.WaitID == waitID {
+			return e, true
+		}
+	}
+	return QueueEntry{}, false
+}
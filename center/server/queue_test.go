@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// 按实例容量永远放不下的请求不排队
+func TestWaitRejectsRequestThatNeverFits(t *testing.T) {
+	s := newTestStore(t, Deployment{SiteName: "s1", Gas: 2, Cost: 1}) // 2 个实例，各 1 slot
+	_, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc", Gas: 5, WaitTimeoutMs: 60000})
+	if !errors.Is(err, ErrNeverFits) {
+		t.Fatalf("want ErrNeverFits, got %v", err)
+	}
+	if q := s.QueueStatus("svc"); len(q) != 0 {
+		t.Fatalf("queue = %v, want empty", q)
+	}
+}
+
+// 排在前面但满足不了的大请求不挡住后面的小请求，也不挡住新请求
+func TestWaitersBehindUnservableHeadAreServed(t *testing.T) {
+	s := newTestStore(t,
+		Deployment{SiteName: "s1", Gas: 2, Cost: 1, Instances: []Instance{{InstanceID: "s1-a", Addr: "/s1-a", Capacity: 2}}},
+		Deployment{SiteName: "s2", Gas: 1, Cost: 1},
+	)
+	ctx := context.Background()
+	big, err := s.Allocate(ctx, AllocateRequest{ServiceID: "svc", Gas: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := s.Allocate(ctx, AllocateRequest{ServiceID: "svc", Gas: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headDone := make(chan error, 1)
+	go func() {
+		_, err := s.Allocate(ctx, AllocateRequest{ServiceID: "svc", Gas: 2, WaitTimeoutMs: 5000, WaitID: "head"})
+		headDone <- err
+	}()
+	waitQueued(t, s, 1)
+	nextDone := make(chan error, 1)
+	go func() {
+		_, err := s.Allocate(ctx, AllocateRequest{ServiceID: "svc", Gas: 1, WaitTimeoutMs: 5000, WaitID: "next"})
+		nextDone <- err
+	}()
+	waitQueued(t, s, 2)
+
+	// 还回 1 个 slot：队首（Gas 2）放不下，第二个（Gas 1）应拿到
+	if err := s.Release(small.AllocationID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-nextDone:
+		if err != nil {
+			t.Fatalf("second waiter: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("second waiter not served while a slot was free")
+	}
+
+	// 大请求拿到 s1-a 之后，队首完成
+	if err := s.Release(big.AllocationID); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-headDone; err != nil {
+		t.Fatalf("head waiter: %v", err)
+	}
+}
+
+func TestNewRequestNotBlockedByQueuedWaiter(t *testing.T) {
+	s := newTestStore(t,
+		Deployment{SiteName: "s1", Gas: 2, Cost: 1, Instances: []Instance{{InstanceID: "s1-a", Addr: "/s1-a", Capacity: 2}}},
+		Deployment{SiteName: "s2", Gas: 1, Cost: 1},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := s.Allocate(ctx, AllocateRequest{ServiceID: "svc", Gas: 2}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = s.Allocate(ctx, AllocateRequest{ServiceID: "svc", Gas: 2, WaitTimeoutMs: 5000})
+	}()
+	waitQueued(t, s, 1)
+
+	if _, err := s.Allocate(context.Background(), AllocateRequest{ServiceID: "svc", Gas: 1, WaitTimeoutMs: 1000}); err != nil {
+		t.Fatalf("new request with a free slot: %v", err)
+	}
+}
+
+func waitQueued(t *testing.T, s *Store, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.QueueStatus("svc")) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue never reached %d waiter(s)", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
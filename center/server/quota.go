@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ====== per-client / per-tenant quota ======
+// Quota 按主体（client 或 tenant）限制同时持有的 slot 数（MaxSlots）和分配速率（RatePerMin，令牌桶）。
+// 主体 ID 为 "*" 的是该类主体的默认配额；具体 ID 的配置优先（匿名调用方不套默认，见 sharedSubject）。
+// 限制在 Store 的分配路径（admitLocked）里检查，超限返回 ErrQuotaExceeded（HTTP 429）。
+
+const (
+	QuotaClient = "client"
+	QuotaTenant = "tenant"
+
+	anonymousClient = "anonymous"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+type Quota struct {
+	Kind       string `json:"kind"` // client | tenant
+	ID         string `json:"id"`   // "*" = 默认
+	MaxSlots   int    `json:"maxSlots,omitempty"`
+	RatePerMin int    `json:"ratePerMin,omitempty"`
+	Burst      int    `json:"burst,omitempty"` // 令牌桶容量，默认 = RatePerMin
+}
+
+func quotaKey(kind, id string) string { return kind + "/" + id }
+
+func (q Quota) validate() error {
+	if q.Kind != QuotaClient && q.Kind != QuotaTenant {
+		return fmt.Errorf("%w: kind must be client or tenant", ErrBadRequest)
+	}
+	if strings.TrimSpace(q.ID) == "" {
+		return fmt.Errorf("%w: missing id", ErrBadRequest)
+	}
+	if q.MaxSlots < 0 || q.RatePerMin < 0 || q.Burst < 0 {
+		return fmt.Errorf("%w: limits must be >= 0", ErrBadRequest)
+	}
+	return nil
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take 按 q 的速率补充令牌，够 n 个则扣除并返回 true
+func (b *tokenBucket) take(q Quota, n int, now time.Time) bool {
+	burst := float64(q.Burst)
+	if burst <= 0 {
+		burst = float64(q.RatePerMin)
+	}
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Minutes()*float64(q.RatePerMin))
+	}
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+func (s *Store) UpsertQuota(q Quota) error {
+	if err := q.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := quotaKey(q.Kind, q.ID)
+	s.quotas[key] = q
+	delete(s.buckets, key) // 配额变了，令牌桶重新开始
+	return nil
+}
+
+func (s *Store) DeleteQuota(kind, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := quotaKey(kind, id)
+	delete(s.quotas, key)
+	delete(s.buckets, key)
+}
+
+// ListQuotas 返回全部配额及主体当前持有的 slot 数（默认配额不统计）
+func (s *Store) ListQuotas() []QuotaStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]QuotaStatus, 0, len(s.quotas))
+	for _, q := range s.quotas {
+		st := QuotaStatus{Quota: q}
+		if q.ID != "*" {
+			st.HeldSlots = s.heldByLocked(q.Kind, q.ID, "")
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return quotaKey(out[i].Kind, out[i].ID) < quotaKey(out[j].Kind, out[j].ID)
+	})
+	return out
+}
+
+// sharedSubject：没带 ClientID 的调用方都记为 anonymous，不是一个真实主体，
+// "*" 默认配额 / 预算不套到它身上（否则一个匿名调用方就能用光所有匿名调用方的额度）；
+// 需要限制匿名流量时显式配置 ID 为 anonymous 的条目
+func sharedSubject(kind, id string) bool { return kind == QuotaClient && id == anonymousClient }
+
+// effectiveQuotaLocked：具体 ID 优先，其次 "*"
+func (s *Store) effectiveQuotaLocked(kind, id string) (Quota, string, bool) {
+	if q, ok := s.quotas[quotaKey(kind, id)]; ok {
+		return q, quotaKey(kind, id), true
+	}
+	if sharedSubject(kind, id) {
+		return Quota{}, "", false
+	}
+	if q, ok := s.quotas[quotaKey(kind, "*")]; ok {
+		// 默认配额的令牌桶按具体主体分开计
+		return q, quotaKey(kind, id), true
+	}
+	return Quota{}, "", false
+}
+
+// heldByLocked 统计主体当前持有的 slot；serviceID 为空表示所有 service
+func (s *Store) heldByLocked(kind, id, serviceID string) int {
+	n := 0
+	for _, rec := range s.allocations {
+		if serviceID != "" && rec.ServiceID != serviceID {
+			continue
+		}
+		if (kind == QuotaClient && rec.ClientID == id) || (kind == QuotaTenant && rec.TenantID == id) {
+			n += rec.gas()
+		}
+	}
+	return n
+}
+
+// admitLocked 在真正分配前检查配额：slots 为本次要占用的总 slot 数，allocs 为要创建的 allocation 数。
+// 只有全部检查通过才扣令牌，避免被拒的请求消耗速率额度。
+func (s *Store) admitLocked(req AllocateRequest, slots, allocs int) error {
+	rates, err := s.checkQuotaLocked(req, slots, allocs)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, p := range rates {
+		s.bucketLocked(p.key).take(p.q, allocs, now)
+	}
+	return nil
+}
+
+type pendingRate struct {
+	q   Quota
+	key string
+}
+
+// checkQuotaLocked 只检查不扣令牌：抢占之前先调用，超配额的请求不能先撤掉别人的 allocation 再被拒。
+// 返回需要扣令牌的速率配额
+func (s *Store) checkQuotaLocked(req AllocateRequest, slots, allocs int) ([]pendingRate, error) {
+	type subject struct{ kind, id string }
+	subjects := []subject{{QuotaClient, req.ClientID}}
+	if req.TenantID != "" {
+		subjects = append(subjects, subject{QuotaTenant, req.TenantID})
+	}
+
+	now := time.Now()
+	var rates []pendingRate
+	for _, sub := range subjects {
+		q, key, ok := s.effectiveQuotaLocked(sub.kind, sub.id)
+		if !ok {
+			continue
+		}
+		if q.MaxSlots > 0 {
+			if held := s.heldByLocked(sub.kind, sub.id, ""); held+slots > q.MaxSlots {
+				return nil, fmt.Errorf("%w: %s %s holds %d slot(s) + %d requested > max %d",
+					ErrQuotaExceeded, sub.kind, sub.id, held, slots, q.MaxSlots)
+			}
+		}
+		if q.RatePerMin > 0 {
+			rates = append(rates, pendingRate{q, key})
+		}
+	}
+
+	// 在副本上试扣，看所有令牌桶是否都够
+	for _, p := range rates {
+		b := *s.bucketLocked(p.key)
+		if !b.take(p.q, allocs, now) {
+			return nil, fmt.Errorf("%w: rate limit %d/min for %s", ErrQuotaExceeded, p.q.RatePerMin, p.key)
+		}
+	}
+	return rates, nil
+}
+
+func (s *Store) bucketLocked(key string) *tokenBucket {
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		s.buckets[key] = b
+	}
+	return b
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ====== two-phase allocation ======
+// reserve 走和 Allocate 相同的打分/扣 slot 流程（但不抢占），记录标为 Reserved，租约只有 reserveTTL；
+// client 看过报价（实例、价格、到期时间）后 commit（转为正常 allocation，换成完整租约）或 abort（立即归还）。
+// 没 commit 的 reservation 到期由 lease reaper 按过期回收，不需要额外的后台任务。
+
+const defaultReserveTTL = 30 * time.Second
+
+var (
+	ErrNotReserved        = errors.New("allocation is not a pending reservation")
+	ErrReservationExpired = errors.New("reservation expired")
+	ErrReservationPending = errors.New("reservation not committed yet")
+)
+
+// Reserve 按请求的 Gas 预占 slot 并返回报价；reservationId 即之后的 allocationId
+func (s *Store) Reserve(ctx context.Context, req AllocateRequest) (ReserveResponse, error) {
+	if req.Count > 1 {
+		return ReserveResponse{}, fmt.Errorf("%w: reserve supports a single allocation (Count <= 1)", ErrBadRequest)
+	}
+	req.reserve = true
+	resp, err := s.Allocate(ctx, req)
+	if err != nil {
+		return ReserveResponse{}, err
+	}
+	return ReserveResponse{
+		ReservationID: resp.AllocationID,
+		ServiceID:     resp.ServiceID,
+		InstanceID:    resp.InstanceID,
+		Addr:          resp.Addr,
+		CSCI_ID:       resp.CSCI_ID,
+		Cost:          resp.Cost,
+		Price:         resp.Price,
+		Gas:           resp.Gas,
+		GasRemaining:  resp.GasRemaining,
+		ExpiresAt:     resp.ExpiresAt,
+	}, nil
+}
+
+// pendingReservationLocked 取出一个未过期的 reservation；已过期的顺手回收
+func (s *Store) pendingReservationLocked(id string, now time.Time) (AllocationRecord, error) {
+	rec, ok := s.allocations[id]
+	if !ok {
+		return AllocationRecord{}, errors.New("reservation not found")
+	}
+	if !rec.Reserved {
+		return AllocationRecord{}, ErrNotReserved
+	}
+	if now.After(rec.ExpiresAt) {
+		_ = s.releaseLocked(id, EndAborted)
+		return AllocationRecord{}, ErrReservationExpired
+	}
+	return rec, nil
+}
+
+// CommitReservation 确认 reservation：转为正常 allocation，租约从现在起算
+func (s *Store) CommitReservation(id string) (AllocateResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	rec, err := s.pendingReservationLocked(id, now)
+	if err != nil {
+		return AllocateResponse{}, err
+	}
+	rec.Reserved = false
+	rec.ExpiresAt = now.Add(s.leaseTTL)
+	s.allocations[id] = rec
+	s.recordEventLocked(EventCommitted, id, rec, nil, now)
+
+	resp := AllocateResponse{
+		AllocationID: id,
+		ServiceID:    rec.ServiceID,
+		InstanceID:   rec.InstanceID,
+		Cost:         rec.Cost,
+		Price:        rec.Price,
+		ExpiresAt:    rec.ExpiresAt,
+		Weights:      rec.Weights,
+		Gas:          rec.gas(),
+	}
+	if st := s.deployments[rec.SiteName][rec.ServiceID]; st != nil {
+		resp.CSCI_ID = st.Deployment.CSCI_ID
+		resp.GasRemaining = st.GasAvailable
+		if inst := st.instance(rec.InstanceID); inst != nil {
+			resp.Addr = inst.Addr
+		}
+	}
+	return resp, nil
+}
+
+// AbortReservation 放弃 reservation，slot 立即归还
+func (s *Store) AbortReservation(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.pendingReservationLocked(id, time.Now()); err != nil {
+		return err
+	}
+	return s.releaseLocked(id, EndAborted)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+// ====== scoring strategies ======
+// Scorer 决定 Allocate 在候选实例中选谁。约定：Rank 就地重排 cands，cands[0] 即选中者；
+// score 越小越好（展示/调试用），不同策略的 score 口径可以不同。
+
+type Scorer interface {
+	Rank(cands []scored, req AllocateRequest)
+}
+
+// ScorerFunc 让普通函数也能当 Scorer 注册
+type ScorerFunc func(cands []scored, req AllocateRequest)
+
+func (f ScorerFunc) Rank(cands []scored, req AllocateRequest) { f(cands, req) }
+
+const defaultStrategy = "weighted"
+
+var (
+	scorersMu sync.RWMutex
+	scorers   = map[string]Scorer{}
+)
+
+// RegisterScorer 注册（或覆盖）一个命名策略
+func RegisterScorer(name string, sc Scorer) {
+	scorersMu.Lock()
+	defer scorersMu.Unlock()
+	scorers[name] = sc
+}
+
+func lookupScorer(name string) (Scorer, error) {
+	scorersMu.RLock()
+	defer scorersMu.RUnlock()
+	sc, ok := scorers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown strategy %q", ErrBadRequest, name)
+	}
+	return sc, nil
+}
+
+// ScorerNames 列出已注册策略（排序后，便于展示）
+func ScorerNames() []string {
+	scorersMu.RLock()
+	defer scorersMu.RUnlock()
+	out := make([]string, 0, len(scorers))
+	for name := range scorers {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// resolveStrategy：请求里的 Strategy 优先，其次 service 默认，最后全局默认
+func resolveStrategy(req AllocateRequest, svc Service) string {
+	if req.Strategy != "" {
+		return req.Strategy
+	}
+	if svc.Strategy != "" {
+		return svc.Strategy
+	}
+	return defaultStrategy
+}
+
+func init() {
+	RegisterScorer("weighted", ScorerFunc(rankWeighted))
+	RegisterScorer("lexicographic", ScorerFunc(rankLexicographic))
+	RegisterScorer("random", ScorerFunc(rankRandom))
+	RegisterScorer("p2c", ScorerFunc(rankPowerOfTwo))
+	RegisterScorer("least-loaded", ScorerFunc(rankLeastLoaded))
+}
+
+// sortByScore：score 升序，相同则 delay 小的优先
+func sortByScore(cands []scored) {
+	sort.SliceStable(cands, func(i, j int) bool {
+		if cands[i].score == cands[j].score {
+			return cands[i].m.DelayMs < cands[j].m.DelayMs
+		}
+		return cands[i].score < cands[j].score
+	})
+}
+
+// fillUnknownCompute 把计算耗时未知（< 0）的候选按已知候选里最慢的算：未知不能排成最快。
+// 全部未知时都按 0（该维度不影响排序）
+func fillUnknownCompute(cands []scored) {
+	slowest := 0.0
+	for _, c := range cands {
+		slowest = max(slowest, c.computeMs)
+	}
+	for i := range cands {
+		if cands[i].computeMs < 0 {
+			cands[i].computeMs = slowest
+		}
+	}
+}
+
+// fillNorms 把各维度 min-max 归一化到 0~1，写入 cands[i].norm
+func fillNorms(cands []scored) {
+	costs := make([]float64, len(cands))
+	delays := make([]float64, len(cands))
+	computes := make([]float64, len(cands))
+	loads := make([]float64, len(cands))
+	failures := make([]float64, len(cands))
+	for i, c := range cands {
+		costs[i] = c.price
+		delays[i] = float64(c.m.DelayMs)
+		computes[i] = c.computeMs
+		loads[i] = c.util
+		failures[i] = 1 - c.reliability
+	}
+	nCost := minMaxNorm(costs)
+	nDelay := minMaxNorm(delays)
+	nCompute := minMaxNorm(computes)
+	nLoad := minMaxNorm(loads)
+	nFail := minMaxNorm(failures)
+
+	for i := range cands {
+		cands[i].norm = Weights{Cost: nCost[i], Delay: nDelay[i], ComputeTime: nCompute[i], Load: nLoad[i], Reliability: nFail[i]}
+	}
+}
+
+// weighted：各维度 min-max 归一化后按权重加权求和
+func weightedScores(cands []scored, req AllocateRequest) {
+	w := reqWeights(req)
+	fillNorms(cands)
+	for i, c := range cands {
+		cands[i].score = w.Cost*c.norm.Cost + w.Delay*c.norm.Delay + w.ComputeTime*c.norm.ComputeTime +
+			w.Load*c.norm.Load + w.Reliability*c.norm.Reliability
+	}
+}
+
+func rankWeighted(cands []scored, req AllocateRequest) {
+	weightedScores(cands, req)
+	sortByScore(cands)
+}
+
+// lexicographic：权重大的维度为主键，另一维度只用于打破平局
+func rankLexicographic(cands []scored, req AllocateRequest) {
+	w := reqWeights(req)
+	costFirst := w.Cost >= w.Delay
+
+	sort.SliceStable(cands, func(i, j int) bool {
+		a, b := cands[i], cands[j]
+		if costFirst {
+			if a.price != b.price {
+				return a.price < b.price
+			}
+			return a.m.DelayMs < b.m.DelayMs
+		}
+		if a.m.DelayMs != b.m.DelayMs {
+			return a.m.DelayMs < b.m.DelayMs
+		}
+		return a.price < b.price
+	})
+	for i := range cands {
+		cands[i].score = float64(i) // 名次即分数
+	}
+}
+
+// random：均匀随机挑一个（基线对照用）
+func rankRandom(cands []scored, req AllocateRequest) {
+	rand.Shuffle(len(cands), func(i, j int) { cands[i], cands[j] = cands[j], cands[i] })
+	for i := range cands {
+		cands[i].score = float64(i)
+	}
+}
+
+// p2c：power-of-two-choices，随机抽两个，按 weighted 分数取较优者
+func rankPowerOfTwo(cands []scored, req AllocateRequest) {
+	weightedScores(cands, req)
+	if len(cands) < 2 {
+		return
+	}
+	i := rand.IntN(len(cands))
+	j := rand.IntN(len(cands) - 1)
+	if j >= i {
+		j++
+	}
+	if cands[j].score < cands[i].score {
+		i = j
+	}
+	cands[0], cands[i] = cands[i], cands[0]
+}
+
+// least-loaded：选实例占用率最低的（Available/Capacity 越大越好），平局看 delay
+func rankLeastLoaded(cands []scored, req AllocateRequest) {
+	for i, c := range cands {
+		cands[i].score = 1
+		if c.capacity > 0 {
+			cands[i].score = 1 - float64(c.available)/float64(c.capacity)
+		}
+	}
+	sortByScore(cands)
+}
@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+// 字符串简写保持原来的 cost / delay 两维权重，不能悄悄改变老客户端的排序
+func TestShorthandWeightsStayLegacy(t *testing.T) {
+	svc := Service{ServiceID: "svc", DefaultWeights: &Weights{Cost: 1, ComputeTime: 1}}
+	for _, tc := range []struct {
+		costPref, delayPref string
+		want                Weights
+	}{
+		{"most", "least", Weights{Cost: 0.7, Delay: 0.3}},
+		{"least", "most", Weights{Cost: 0.3, Delay: 0.7}},
+		{"most", "", Weights{Cost: 0.5, Delay: 0.5}},
+	} {
+		w, err := resolveWeights(AllocateRequest{CostPref: tc.costPref, DelayPref: tc.delayPref}, svc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w != tc.want {
+			t.Errorf("%s/%s: weights %+v, want %+v", tc.costPref, tc.delayPref, w, tc.want)
+		}
+	}
+}
+
+// 计算耗时 / 负载只能显式打开：请求 Weights 或 service DefaultWeights
+func TestComputeAndLoadWeightsAreOptIn(t *testing.T) {
+	if w, _ := resolveWeights(AllocateRequest{}, Service{}); w != (Weights{Cost: 0.5, Delay: 0.5}) {
+		t.Fatalf("no prefs, no service default: %+v", w)
+	}
+	svc := Service{DefaultWeights: &Weights{Cost: 2, Delay: 1, ComputeTime: 1}}
+	w, err := resolveWeights(AllocateRequest{}, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != (Weights{Cost: 0.5, Delay: 0.25, ComputeTime: 0.25}) {
+		t.Fatalf("service default: %+v", w)
+	}
+	w, err = resolveWeights(AllocateRequest{Weights: &Weights{Load: 1}}, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != (Weights{Load: 1}) {
+		t.Fatalf("request weights should win over service default: %+v", w)
+	}
+}
+
+// ComputingTime 解析不出的部署按已知最慢的算，不能排成最快
+func TestUnparseableComputingTimeIsNotFastest(t *testing.T) {
+	s := newTestStore(t,
+		Deployment{SiteName: "fast", Gas: 1, Cost: 1, ComputingTime: "1s"},
+		Deployment{SiteName: "slow", Gas: 1, Cost: 1, ComputingTime: "3s"},
+		Deployment{SiteName: "odd", Gas: 1, Cost: 1, ComputingTime: "soon"},
+	)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plan, err := s.planLocked(AllocateRequest{ServiceID: "svc", Weights: &Weights{ComputeTime: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, c := range plan.cands {
+		got[c.m.SiteName] = c.computeMs
+	}
+	if got["odd"] != 3000 {
+		t.Fatalf("unparseable computeMs = %v, want 3000 (slowest known); all: %v", got["odd"], got)
+	}
+	if plan.cands[0].m.SiteName != "fast" {
+		t.Fatalf("chosen %s, want fast", plan.cands[0].m.SiteName)
+	}
+}
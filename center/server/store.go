@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 type Store struct {
@@ -16,19 +17,84 @@ type Store struct {
 	deployments map[string]map[string]*DeploymentState       // SiteName -> ServiceID -> state
 	allocations map[string]AllocationRecord                  // allocationId -> record
 	lastDelay   map[string]int                               // instanceId -> last delay ms (展示用)
-
-	dataDir string
+	delayHist   map[string]*DelayHistory                     // instanceId -> 最近测量 + EWMA
+	feedback    map[string]*InstanceFeedback                 // instanceId -> 调用反馈累计
+	bandit      map[string]*BanditArm                        // "serviceId/instanceId" -> bandit arm 统计
+	idempotency map[string]*IdempotencyEntry                 // "scope:key" -> 首次成功响应（Idempotency-Key 重试回放）
+	ledger      []LedgerEntry                                // 计费账本（单独存 ledger.jsonl，只追加）
+	history     []AllocationEvent                            // allocation 生命周期事件（单独存 history.jsonl，只追加）
+	probes      map[string]*ProbeState                       // instanceId -> center 主动探测结果（不持久化）
+	health      map[string]*InstanceHealth                   // instanceId -> 健康状态/断路器（不持久化）
+	pingNonces  map[string]time.Time                         // 已用过的 ping token nonce -> 过期时间（防重放，不持久化）
+	waiters     map[string][]*waiter                         // ServiceID -> 等容量的请求（按优先级 + FIFO，不持久化）
+	serving     map[string]bool                              // 正在 serveWaitersLocked 的 ServiceID -> 期间又有 slot 归还
+	revoked     map[string]AllocationRecord                  // allocationId -> 被抢占的记录（保留一段时间供 owner 查询）
+	quotas      map[string]Quota                             // "client/{id}" | "tenant/{id}" -> 配额
+	buckets     map[string]*tokenBucket                      // 同上 key -> 速率令牌桶（不持久化）
+	budgets     map[string]Budget                            // "client/{id}" | "tenant/{id}" -> 周期花费预算
+	affinity    map[string]AffinityEntry                     // "{ServiceID}/{AffinityKey}" -> 上次实例
+
+	dataDir  string
+	leaseTTL time.Duration // allocation 租约时长，client 需在到期前 renew
+
+	affinityTTL time.Duration // 亲和条目闲置多久后失效
+	probeEvery  time.Duration // center 主动探测周期
+
+	breakerCooldown time.Duration // 断路器 open 多久后 half-open
+	idempotencyTTL  time.Duration // Idempotency-Key 响应保留多久
+	reserveTTL      time.Duration // reservation 未 commit 的保留时长
 }
 
 type DeploymentState struct {
 	Deployment   Deployment
-	GasAvailable int // 内部逻辑，不在页面显示
+	GasAvailable int // 内部逻辑，不在页面显示；= 各实例 Available 之和
+}
+
+// instance 返回指向 Deployment.Instances 中对应实例的指针（用于改 Available）
+func (st *DeploymentState) instance(instanceID string) *Instance {
+	for i := range st.Deployment.Instances {
+		if st.Deployment.Instances[i].InstanceID == instanceID {
+			return &st.Deployment.Instances[i]
+		}
+	}
+	return nil
 }
 
 type AllocationRecord struct {
-	ServiceID  string `json:"serviceId"`
-	SiteName   string `json:"siteName"`
-	InstanceID string `json:"instanceId"`
+	ServiceID   string    `json:"serviceId"`
+	SiteName    string    `json:"siteName"`
+	InstanceID  string    `json:"instanceId"`
+	AllocatedAt time.Time `json:"allocatedAt"`
+	ExpiresAt   time.Time `json:"expiresAt"` // 租约到期时间；过期由 reaper 回收
+	Gas         int       `json:"gas"`       // 占用的 slot 数，Release 时原样归还
+
+	ClientID    string `json:"clientId,omitempty"`
+	TenantID    string `json:"tenantId,omitempty"`
+	AffinityKey string `json:"affinityKey,omitempty"` // 续租时一并刷新亲和条目
+	Priority    string `json:"priority,omitempty"`    // 优先级类别（见 priority.go），空 = standard
+	NotifyURL   string `json:"notifyUrl,omitempty"`   // 被抢占时 center POST 通知的地址
+
+	RevokedAt    time.Time `json:"revokedAt,omitempty"`
+	RevokeReason string    `json:"revokeReason,omitempty"`
+
+	FeedbackReported bool `json:"feedbackReported,omitempty"` // 已上报过调用结果（每个 allocation 只收一次）
+
+	Cost     int     `json:"cost,omitempty"`     // 分配时的基础价
+	Price    float64 `json:"price,omitempty"`    // 分配时的单 slot 生效价格（动态定价后）
+	Weights  Weights `json:"weights"`            // 分配时生效的权重
+	Reserved bool    `json:"reserved,omitempty"` // 两阶段分配中尚未 commit 的 reservation
+
+	// client 上报的用量（feedback / release 时累加），结束时写入账本
+	Tokens    int `json:"tokens,omitempty"`
+	ComputeMs int `json:"computeMs,omitempty"`
+}
+
+// gas 兼容老快照（没有 gas 字段的记录按 1 计）
+func (r AllocationRecord) gas() int {
+	if r.Gas < 1 {
+		return 1
+	}
+	return r.Gas
 }
 
 // ====== persistence snapshot ======
@@ -38,6 +104,32 @@ type storeSnapshot struct {
 	Deployments map[string]map[string]*DeploymentState `json:"deployments"`
 	Allocations map[string]AllocationRecord           `json:"allocations"`
 	LastDelay   map[string]int                        `json:"lastDelay"`
+	DelayHist   map[string]*DelayHistory              `json:"delayHistory"`
+	Feedback    map[string]*InstanceFeedback          `json:"feedback"`
+	Bandit      map[string]*BanditArm                 `json:"bandit"`
+	Idempotency map[string]*IdempotencyEntry          `json:"idempotency"`
+	Revoked     map[string]AllocationRecord           `json:"revoked"`
+	Quotas      map[string]Quota                      `json:"quotas"`
+	Budgets     map[string]Budget                     `json:"budgets"`
+	Affinity    map[string]AffinityEntry              `json:"affinity"`
+}
+
+// snapshotLocked 生成落盘快照；调用方需持有 s.mu
+func (s *Store) snapshotLocked() storeSnapshot {
+	return storeSnapshot{
+		Services:    s.services,
+		Deployments: s.deployments,
+		Allocations: s.allocations,
+		LastDelay:   s.lastDelay,
+		DelayHist:   s.delayHist,
+		Feedback:    s.feedback,
+		Bandit:      s.bandit,
+		Idempotency: s.idempotency,
+		Revoked:     s.revoked,
+		Quotas:      s.quotas,
+		Budgets:     s.budgets,
+		Affinity:    s.affinity,
+	}
 }
 
 func NewStore() *Store {
@@ -46,13 +138,35 @@ func NewStore() *Store {
 		dir = "/data"
 	}
 	s := &Store{
-		services:    map[string]Service{},
-		deployments: map[string]map[string]*DeploymentState{},
-		allocations: map[string]AllocationRecord{},
-		lastDelay:   map[string]int{},
-		dataDir:     dir,
+		services:        map[string]Service{},
+		deployments:     map[string]map[string]*DeploymentState{},
+		allocations:     map[string]AllocationRecord{},
+		lastDelay:       map[string]int{},
+		delayHist:       map[string]*DelayHistory{},
+		feedback:        map[string]*InstanceFeedback{},
+		bandit:          map[string]*BanditArm{},
+		idempotency:     map[string]*IdempotencyEntry{},
+		probes:          map[string]*ProbeState{},
+		health:          map[string]*InstanceHealth{},
+		pingNonces:      map[string]time.Time{},
+		waiters:         map[string][]*waiter{},
+		serving:         map[string]bool{},
+		revoked:         map[string]AllocationRecord{},
+		quotas:          map[string]Quota{},
+		buckets:         map[string]*tokenBucket{},
+		budgets:         map[string]Budget{},
+		affinity:        map[string]AffinityEntry{},
+		dataDir:         dir,
+		leaseTTL:        leaseTTLFromEnv(),
+		affinityTTL:     durationFromEnv("AFFINITY_TTL_SEC", defaultAffinityTTL),
+		probeEvery:      durationFromEnv("PROBE_INTERVAL_SEC", defaultProbeEvery),
+		breakerCooldown: durationFromEnv("BREAKER_COOLDOWN_SEC", defaultBreakerCooldown),
+		reserveTTL:      durationFromEnv("RESERVE_TTL_SEC", defaultReserveTTL),
+		idempotencyTTL:  durationFromEnv("IDEMPOTENCY_TTL_SEC", defaultIdempotencyTTL),
 	}
 	_ = s.LoadFromDisk() // 启动即尝试恢复
+	s.loadLedger()
+	s.loadHistory()
 	return s
 }
 
@@ -91,25 +205,71 @@ func (s *Store) LoadFromDisk() error {
 	if snap.LastDelay == nil {
 		snap.LastDelay = map[string]int{}
 	}
+	if snap.DelayHist == nil {
+		snap.DelayHist = map[string]*DelayHistory{}
+	}
+	if snap.Feedback == nil {
+		snap.Feedback = map[string]*InstanceFeedback{}
+	}
+	if snap.Bandit == nil {
+		snap.Bandit = map[string]*BanditArm{}
+	}
+	if snap.Idempotency == nil {
+		snap.Idempotency = map[string]*IdempotencyEntry{}
+	}
+	if snap.Revoked == nil {
+		snap.Revoked = map[string]AllocationRecord{}
+	}
+	if snap.Quotas == nil {
+		snap.Quotas = map[string]Quota{}
+	}
+	if snap.Budgets == nil {
+		snap.Budgets = map[string]Budget{}
+	}
+	if snap.Affinity == nil {
+		snap.Affinity = map[string]AffinityEntry{}
+	}
+
+	// 老快照里的实例没有 Capacity/Available：按当前 allocations 重新推算
+	for siteName, bySvc := range snap.Deployments {
+		for serviceID, st := range bySvc {
+			if st == nil {
+				delete(bySvc, serviceID)
+				continue
+			}
+			initInstanceSlots(&st.Deployment, heldByInstance(snap.Allocations, siteName, serviceID))
+			st.GasAvailable = sumAvailable(st.Deployment.Instances)
+		}
+	}
+
+	// 老快照里的 allocation 没有租约：从现在起补一个完整租约，之后照常过期回收
+	now := time.Now()
+	for aid, rec := range snap.Allocations {
+		if rec.ExpiresAt.IsZero() {
+			rec.ExpiresAt = now.Add(s.leaseTTL)
+			snap.Allocations[aid] = rec
+		}
+	}
 
 	s.services = snap.Services
 	s.deployments = snap.Deployments
 	s.allocations = snap.Allocations
 	s.lastDelay = snap.LastDelay
+	s.delayHist = snap.DelayHist
+	s.feedback = snap.Feedback
+	s.bandit = snap.Bandit
+	s.idempotency = snap.Idempotency
+	s.revoked = snap.Revoked
+	s.quotas = snap.Quotas
+	s.budgets = snap.Budgets
+	s.affinity = snap.Affinity
 	return nil
 }
 
 func (s *Store) saveLocked() error {
 	_ = os.MkdirAll(s.dataDir, 0755)
 
-	snap := storeSnapshot{
-		Services:    s.services,
-		Deployments: s.deployments,
-		Allocations: s.allocations,
-		LastDelay:   s.lastDelay,
-	}
-
-	b, err := json.MarshalIndent(snap, "", "  ")
+	b, err := json.MarshalIndent(s.snapshotLocked(), "", "  ")
 	if err != nil {
 		return err
 	}
@@ -210,25 +370,81 @@ func (s *Store) UpsertDeployment(dep Deployment) error {
 		dep.Instances = buildInstances(dep.SiteName, dep.Gas)
 	}
 
+	s.putDeploymentLocked(dep)
+	return s.saveLocked()
+}
+
+// putDeploymentLocked 写入/覆盖部署：按实例初始化 slot，已有 allocation 占用的 slot 保持扣减
+func (s *Store) putDeploymentLocked(dep Deployment) *DeploymentState {
 	if s.deployments[dep.SiteName] == nil {
 		s.deployments[dep.SiteName] = map[string]*DeploymentState{}
 	}
+	initInstanceSlots(&dep, heldByInstance(s.allocations, dep.SiteName, dep.ServiceID))
+	st := &DeploymentState{
+		Deployment:   dep,
+		GasAvailable: sumAvailable(dep.Instances),
+	}
+	s.deployments[dep.SiteName][dep.ServiceID] = st
+
+	// 新部署/扩容可能让排队的请求得到满足
+	s.serveWaitersLocked(dep.ServiceID)
+	return st
+}
 
-	// 如果已存在，尽量保留 GasAvailable（但不超过新 Gas）
-	old := s.deployments[dep.SiteName][dep.ServiceID]
-	gasAvail := dep.Gas
-	if old != nil {
-		gasAvail = old.GasAvailable
-		if gasAvail > dep.Gas {
-			gasAvail = dep.Gas
+// initInstanceSlots 规范化每个实例的 Capacity，并按 held 计算 Available：
+// - 有实例显式填了 Capacity 的，以显式值为准（未填的按 1）
+// - 都没填时，把 Gas 均分到各实例（每个至少 1，余数给前面的实例）
+// 最后 Deployment.Gas = 各实例 Capacity 之和，保证 "available/total" 口径一致
+func initInstanceSlots(dep *Deployment, held map[string]int) {
+	n := len(dep.Instances)
+	if n == 0 {
+		return
+	}
+	explicit := false
+	for _, inst := range dep.Instances {
+		if inst.Capacity > 0 {
+			explicit = true
+			break
 		}
 	}
-	s.deployments[dep.SiteName][dep.ServiceID] = &DeploymentState{
-		Deployment:   dep,
-		GasAvailable: gasAvail,
+	total := 0
+	for i := range dep.Instances {
+		inst := &dep.Instances[i]
+		if !explicit {
+			inst.Capacity = dep.Gas / n
+			if i < dep.Gas%n {
+				inst.Capacity++
+			}
+		}
+		if inst.Capacity < 1 {
+			inst.Capacity = 1
+		}
+		inst.Available = inst.Capacity - held[inst.InstanceID]
+		if inst.Available < 0 {
+			inst.Available = 0
+		}
+		total += inst.Capacity
 	}
+	dep.Gas = total
+}
 
-	return s.saveLocked()
+// heldByInstance 统计某部署下每个实例被 allocation 占用的 slot 数
+func heldByInstance(allocs map[string]AllocationRecord, siteName, serviceID string) map[string]int {
+	held := map[string]int{}
+	for _, rec := range allocs {
+		if rec.SiteName == siteName && rec.ServiceID == serviceID {
+			held[rec.InstanceID] += rec.gas()
+		}
+	}
+	return held
+}
+
+func sumAvailable(insts []Instance) int {
+	n := 0
+	for _, inst := range insts {
+		n += inst.Available
+	}
+	return n
 }
 
 func (s *Store) ListDeployments() []Deployment {
@@ -270,7 +486,7 @@ func (s *Store) DeleteDeployment(siteName, serviceID string) error {
 func (s *Store) SetLastDelay(instanceID string, delayMs int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.lastDelay[instanceID] = delayMs
+	s.recordDelayLocked(instanceID, delayMs, time.Now())
 	return s.saveLocked()
 }
 
@@ -280,13 +496,15 @@ func (s *Store) GetLastDelay(instanceID string) int {
 	return s.lastDelay[instanceID]
 }
 
-// ====== allocation (扣 Gas=1) ======
+// ====== allocation (每次扣请求的 Gas 个 slot，默认 1) ======
 
 var (
 	ErrNotFound      = errors.New("not found")
 	ErrNoGas         = errors.New("no gas available")
 	ErrNoInstance    = errors.New("no instance")
 	ErrBadAllocation = errors.New("bad allocation id")
+	ErrBadRequest    = errors.New("bad request") // 参数不合法，handler 映射为 400
+	ErrNoCandidates  = errors.New("no available candidates (Gas exhausted or no deployment)")
 )
 
 
@@ -300,16 +518,10 @@ func (s *Store) SaveToDisk() error {
 
 	_ = os.MkdirAll(filepath.Dir(path), 0755)
 
+	// 快照里的 map 会被其它 handler / 后台 reaper 并发修改，序列化要在锁内完成
 	s.mu.Lock()
-	snap := storeSnapshot{
-		Services:    s.services,
-		Deployments: s.deployments,
-		Allocations: s.allocations,
-		LastDelay:   s.lastDelay,
-	}
+	b, err := json.MarshalIndent(s.snapshotLocked(), "", "  ")
 	s.mu.Unlock()
-
-	b, err := json.MarshalIndent(snap, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestStore 返回数据目录在临时目录下的空 Store，并登记 service svc 与给定部署
+func newTestStore(t *testing.T, deps ...Deployment) *Store {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("DATA_DIR", dir)
+	t.Setenv("STORE_PATH", filepath.Join(dir, "store.json"))
+	s := NewStore()
+	if err := s.UpsertService(Service{ServiceID: "svc"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dep := range deps {
+		dep.ServiceID = "svc"
+		if err := s.UpsertDeployment(dep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// available 返回实例当前剩余 slot
+func (s *Store) available(t *testing.T, siteName, instanceID string) int {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst := s.deployments[siteName]["svc"].instance(instanceID)
+	if inst == nil {
+		t.Fatalf("no instance %s/%s", siteName, instanceID)
+	}
+	return inst.Available
+}
@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 type Service struct {
 	ServiceID            string `json:"ServiceID"`
 	ServiceName          string `json:"ServiceName"`
@@ -10,11 +12,32 @@ type Service struct {
 	StorageRequirement   string `json:"StorageRequirement"`
 	ComputingTime        string `json:"ComputingTime"`
 	SoftwareDependency   string `json:"SoftwareDependency"`
+	Strategy             string `json:"Strategy,omitempty"` // 默认打分策略（见 scorer.go），空则 weighted
+
+	// 保底容量：batch（best-effort）请求分配后，全 service 空闲 slot 不得低于 GuaranteedGas
+	GuaranteedGas int `json:"GuaranteedGas,omitempty"`
+	// 允许高优先级请求在容量不足时抢占低优先级 allocation
+	Preemption bool `json:"Preemption,omitempty"`
+	// fair-share：容量紧张时优先满足当前持有 slot 少的 client
+	FairShare bool `json:"FairShare,omitempty"`
+	// 会话亲和：上次实例的 delay 不超过最优 + 该容忍度（ms）时沿用，0 = 默认 50ms
+	AffinityToleranceMs int `json:"AffinityToleranceMs,omitempty"`
+	// 打分默认使用的 delay 统计量（见 delay.go）
+	DelayStat string `json:"DelayStat,omitempty"`
+	// center 探测 RTT 在打分 delay 中的占比 0~1；0 = 默认（PROBE_BLEND，缺省 0.5）
+	ServerDelayBlend float64 `json:"ServerDelayBlend,omitempty"`
+	// 请求既没给 Weights 也没给 CostPref/DelayPref 时用的权重（可打开 computeTime / load 等维度）
+	DefaultWeights *Weights `json:"DefaultWeights,omitempty"`
 }
 
 type Instance struct {
 	InstanceID string `json:"instanceId"`
 	Addr       string `json:"addr"`
+	ProbeAddr  string `json:"probeAddr,omitempty"` // center 探测用的绝对地址；空则由 Addr + PROBE_BASE_URL 推导
+	Capacity   int    `json:"capacity"`            // 该实例可同时承载的 slot 数；不填则由 Gas 均分
+	Available  int    `json:"available"`           // 剩余 slot（store 维护，提交时忽略）
+	Health     string `json:"health,omitempty"`    // healthy | degraded | down（仅查询时填充）
+	Breaker    string `json:"breaker,omitempty"`   // closed | open | half-open（仅查询时填充）
 }
 
 type Deployment struct {
@@ -24,6 +47,11 @@ type Deployment struct {
 	Cost      int    `json:"Cost"`
 	CSCI_ID   string `json:"CSCI-ID"`
 
+	// 站点级计算耗时覆盖（如该站点 GPU 更快）；空则用 Service.ComputingTime
+	ComputingTime string `json:"ComputingTime,omitempty"`
+	// 可选动态定价（见 pricing.go）；空则固定按 Cost
+	Pricing *PricingPolicy `json:"Pricing,omitempty"`
+
 	Instances []Instance `json:"instances"`
 }
 
@@ -38,6 +66,9 @@ type Candidate struct {
 	Cost      int        `json:"Cost"`
 	CSCI_ID   string     `json:"CSCI-ID"`
 	Instances []Instance `json:"instances"`
+
+	ComputingTime string  `json:"ComputingTime"` // 生效的计算耗时（站点覆盖优先）
+	Price         float64 `json:"Price"`         // 当前单 slot 生效价格（含动态定价）
 }
 
 type CandidatesResponse struct {
@@ -49,6 +80,7 @@ type Measurement struct {
 	InstanceID string `json:"instanceId"`
 	Addr       string `json:"addr"`
 	DelayMs    int    `json:"delayMs"`
+	PingToken  string `json:"pingToken,omitempty"` // 站点 /ping 返回的签名 token（见 pingtoken.go）
 }
 
 type AllocateRequest struct {
@@ -56,6 +88,46 @@ type AllocateRequest struct {
 	Measurements []Measurement `json:"measurements"`
 	CostPref     string        `json:"CostPref"`
 	DelayPref    string        `json:"DelayPref"`
+	Strategy     string        `json:"Strategy,omitempty"` // 打分策略名；空则用 service 默认
+	Gas          int           `json:"Gas,omitempty"`      // 本次占用的 slot 数，默认 1
+
+	// 显式数值权重（>=0，center 归一化为和 1）；给了就忽略 CostPref/DelayPref
+	Weights *Weights `json:"Weights,omitempty"`
+
+	// 批量：Count>1 时一次性原子分配多个 slot；Spread = pack（默认）| spread
+	Count  int    `json:"Count,omitempty"`
+	Spread string `json:"Spread,omitempty"`
+
+	// 容量不足时最多排队等待多久（0 = 不等，立即 409）；WaitID 可由 client 指定，便于查询排队位置
+	WaitTimeoutMs int    `json:"WaitTimeoutMs,omitempty"`
+	WaitID        string `json:"WaitID,omitempty"`
+
+	// 调用方身份，用于配额 / fair-share；ClientID 为空记为 anonymous（不套 "*" 默认配额 / 预算，也不能用 avoidHeldSites / maxPerSite）
+	ClientID string `json:"ClientID,omitempty"`
+	TenantID string `json:"TenantID,omitempty"`
+
+	// 会话亲和 key（如 chat session id）：尽量沿用上次分到的实例
+	AffinityKey string `json:"AffinityKey,omitempty"`
+	// 打分用哪个 delay 统计量：measured（默认）| last | ewma | p50 | p95；空则用 service 默认
+	DelayStat string `json:"DelayStat,omitempty"`
+
+	// 反亲和 / 分散约束（见 placement.go），基于该 ClientID 已持有的 allocation
+	Constraints *PlacementConstraints `json:"Constraints,omitempty"`
+
+	// 优先级类别：batch < standard（默认）< interactive；NotifyURL 用于被抢占时回调（限制见 priority.go）
+	Priority  string `json:"Priority,omitempty"`
+	NotifyURL string `json:"NotifyURL,omitempty"`
+
+	reserve   bool      // 两阶段分配的 reserve 步骤（见 reserve.go），只由 Store.Reserve 设置
+	arrivedAt time.Time // 请求到达时间：pingToken 按它校验新鲜度，排队重试时不会因等待而过期（见 pingtoken.go）
+}
+
+type Weights struct {
+	Cost        float64 `json:"cost"`
+	Delay       float64 `json:"delay"`
+	ComputeTime float64 `json:"computeTime"`
+	Load        float64 `json:"load"`        // 部署占用率（1 - GasAvailable/Gas）
+	Reliability float64 `json:"reliability"` // 调用失败率（1 - 反馈成功率 EWMA）
 }
 
 type AllocateResponse struct {
@@ -66,10 +138,67 @@ type AllocateResponse struct {
 	CSCI_ID      string `json:"CSCI-ID"`
 	Cost         int    `json:"Cost"`
 	GasRemaining int    `json:"GasRemaining"`
+
+	ExpiresAt time.Time `json:"expiresAt"` // 租约到期时间，需在此之前 renew
+	Weights   Weights   `json:"weights"`   // 实际生效（归一化后）的权重
+	Gas       int       `json:"Gas"`       // 本次占用的 slot 数
+	Price     float64   `json:"Price"`     // 单 slot 生效价格（Cost 为基础价）
+}
+
+// reserve 的报价：在 ExpiresAt 之前 commit，否则 slot 自动归还
+type ReserveResponse struct {
+	ReservationID string    `json:"reservationId"`
+	ServiceID     string    `json:"ServiceID"`
+	InstanceID    string    `json:"instanceId"`
+	Addr          string    `json:"addr"`
+	CSCI_ID       string    `json:"CSCI-ID"`
+	Cost          int       `json:"Cost"`
+	Price         float64   `json:"Price"` // 单 slot 生效价格，commit 后按此计价
+	Gas           int       `json:"Gas"`
+	GasRemaining  int       `json:"GasRemaining"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+}
+
+type BatchAllocateResponse struct {
+	Allocations []AllocateResponse `json:"allocations"`
 }
 
 type ReleaseRequest struct {
 	AllocationID string `json:"allocationId"`
+	// 可选：本次调用结果，喂给实例健康状态/断路器；不填则不计
+	Success *bool  `json:"success,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// 可选：用量，记入账本
+	Tokens    int `json:"tokens,omitempty"`
+	ComputeMs int `json:"computeMs,omitempty"`
+}
+
+// POST /api/allocations/{id}/feedback
+type FeedbackRequest struct {
+	Success   *bool  `json:"success"`
+	LatencyMs int    `json:"latencyMs,omitempty"` // 端到端延迟
+	ComputeMs int    `json:"computeMs,omitempty"` // 实例上的计算耗时（同时计入用量）
+	Tokens    int    `json:"tokens,omitempty"`    // token 用量（计入账本）
+	Error     string `json:"error,omitempty"`
+}
+
+type AllocationStatus struct {
+	AllocationID string           `json:"allocationId"`
+	State        string           `json:"state"` // active | reserved | revoked
+	Record       AllocationRecord `json:"record"`
+}
+
+type RevokeNotice struct {
+	AllocationID string    `json:"allocationId"`
+	ServiceID    string    `json:"ServiceID"`
+	InstanceID   string    `json:"instanceId"`
+	Reason       string    `json:"reason"`
+	RevokedAt    time.Time `json:"revokedAt"`
+}
+
+type RenewResponse struct {
+	AllocationID string    `json:"allocationId"`
+	ExpiresAt    time.Time `json:"expiresAt"`
 }
 
 /*
@@ -84,6 +213,21 @@ type CPSViewRow struct {
 	Cost          int    `json:"Cost"`
 	Computingtime string `json:"Computingtime"`
 	Networkdelay  int    `json:"Networkdelay"`
+
+	ComputingTimeMs int64   `json:"ComputingTimeMs"` // Computingtime 解析后的毫秒数，-1 表示解析不出
+	Utilization     float64 `json:"Utilization"`     // 部署占用率 0~1
+	Price           float64 `json:"Price"`           // 当前单 slot 生效价格（含动态定价）
+
+	Instances []InstanceOccupancy `json:"instances"` // 每个实例的占用情况
+}
+
+type InstanceOccupancy struct {
+	InstanceID string `json:"instanceId"`
+	Gas        string `json:"Gas"` // available/capacity
+	Available  int    `json:"available"`
+	Capacity   int    `json:"capacity"`
+	Health     string `json:"health"`
+	Breaker    string `json:"breaker"`
 }
 
 type ClientSelectionRequest struct {
@@ -97,3 +241,68 @@ type ClientSelectionRequest struct {
 type ClientSelectionResponse struct {
 	Ok bool `json:"ok"`
 }
+
+// ====== explain / dry-run ======
+
+type ExplainResponse struct {
+	ServiceID  string              `json:"ServiceID"`
+	Strategy   string              `json:"strategy"`
+	Weights    Weights             `json:"weights"`
+	DelayStat  string              `json:"delayStat"`
+	Affinity   string              `json:"affinity,omitempty"` // 会话亲和 hit / miss 原因
+	Chosen     *ScoredCandidate    `json:"chosen"`             // 真实分配会选中的候选；无可用候选时为 null
+	Candidates []ScoredCandidate   `json:"candidates"`
+	Excluded   []ExcludedCandidate `json:"excluded"`
+}
+
+type ScoredCandidate struct {
+	Rank            int     `json:"rank"` // 从 1 开始
+	SiteName        string  `json:"SiteName"`
+	InstanceID      string  `json:"instanceId"`
+	Addr            string  `json:"addr"`
+	Cost            int     `json:"Cost"`
+	Price           float64 `json:"Price"`           // 生效价格，打分的 cost 维度用它
+	DelayMs         int     `json:"delayMs"`         // 打分实际使用的 delay（按 delayStat）
+	MeasuredDelayMs int     `json:"measuredDelayMs"` // 本次请求带来的原始测量值
+	ServerDelayMs   int     `json:"serverDelayMs"`   // center 探测的 RTT（EWMA），-1 = 无
+	ComputingTimeMs float64 `json:"computingTimeMs"`
+	Utilization     float64 `json:"utilization"`
+	Available       int     `json:"available"`
+	Capacity        int     `json:"capacity"`
+	Health          string  `json:"health"`
+	Reliability     float64 `json:"reliability"` // 反馈成功率 EWMA，无反馈为 1
+	Normalized      Weights `json:"normalized"`  // 各维度归一化值 0~1（越小越好）
+	Score           float64 `json:"score"`       // 越小越好；口径取决于 strategy
+}
+
+type ExcludedCandidate struct {
+	SiteName   string `json:"SiteName"`
+	InstanceID string `json:"instanceId"`
+	DelayMs    int    `json:"delayMs"`
+	Reason     string `json:"reason"`
+}
+
+// ====== wait queue ======
+
+type QueueEntry struct {
+	WaitID    string `json:"waitId"`
+	ServiceID string `json:"ServiceID"`
+	Priority  string `json:"priority"`
+	Position  int    `json:"position"` // 从 1 开始
+	WaitingMs int64  `json:"waitingMs"`
+}
+
+// ====== quotas ======
+
+type QuotaStatus struct {
+	Quota
+	HeldSlots int `json:"heldSlots"` // 该主体当前持有的 slot 数
+}
+
+// ====== delay history ======
+
+type InstanceDelayResponse struct {
+	InstanceID string        `json:"instanceId"`
+	Stats      DelayStats    `json:"stats"`
+	Samples    []DelaySample `json:"samples"` // 按时间先后
+}
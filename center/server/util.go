@@ -3,7 +3,10 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func newID(prefix string) string {
@@ -12,6 +15,34 @@ func newID(prefix string) string {
 	return prefix + "_" + hex.EncodeToString(b)
 }
 
+var computingTimeRe = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*(ms|s|sec|min|m|h)?`)
+
+// parseComputingTime 宽松解析 ComputingTime 文本（如 "~1s (demo)"、"500ms"、"2.5 s"）：
+// 取第一个 "数字+单位"，无单位按秒；解析不出返回 false
+func parseComputingTime(s string) (time.Duration, bool) {
+	if d, err := time.ParseDuration(strings.TrimSpace(s)); err == nil && d >= 0 {
+		return d, true
+	}
+	m := computingTimeRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	unit := time.Second
+	switch strings.ToLower(m[2]) {
+	case "ms":
+		unit = time.Millisecond
+	case "min", "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	}
+	return time.Duration(v * float64(unit)), true
+}
+
 func minMaxNorm(vals []float64) []float64 {
 	if len(vals) == 0 {
 		return vals
@@ -77,6 +77,8 @@ function initDeploymentPage(){
         Gas: Number(($("Gas").value||"0")),
         Cost: Number(($("Cost").value||"0")),
         "CSCI-ID": ($("CSCI_ID").value||"").trim(),
+        ComputingTime: ($("ComputingTime")?.value||"").trim(),
+        Pricing: ($("Pricing")?.value||"").trim() ? JSON.parse($("Pricing").value) : undefined,
         instances: JSON.parse($("Instances").value||"[]"),
       };
       await apiCreateDeployment(dep);
@@ -148,8 +150,11 @@ async function renderCps(){
         <td>${escapeHtml(r["CSCI-ID"]||"")}</td>
         <td>${escapeHtml(r.Gas ?? "")}</td>
         <td>${escapeHtml(r.Cost ?? "")}</td>
-        <td>${escapeHtml(r.Computingtime||"")}</td>
+        <td>${escapeHtml(r.Price ?? "")}</td>
+        <td>${escapeHtml(r.Computingtime||"")}${(r.ComputingTimeMs ?? -1) >= 0 ? ` (${r.ComputingTimeMs}ms)` : ""}</td>
         <td>${escapeHtml(r.Networkdelay ?? "")}</td>
+        <td>${escapeHtml(((r.Utilization ?? 0) * 100).toFixed(0))}%</td>
+        <td>${escapeHtml((r.instances||[]).map(i=>`${i.instanceId} ${i.Gas}${i.health && i.health !== "healthy" ? ` [${i.health}${i.breaker && i.breaker !== "closed" ? "/" + i.breaker : ""}]` : ""}`).join(", "))}</td>
       `;
       tbody.appendChild(tr);
     }
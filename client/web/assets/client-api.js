@@ -33,28 +33,59 @@ async function apiClientSelection(serviceId, costPref, delayPref){
   return r.json();
 }
 
+// client 身份：每个浏览器一个随机 id（center 据此做配额 / fair-share）
+function getClientId(){
+  const KEY = "cps_client_id";
+  let id = localStorage.getItem(KEY);
+  if(!id){
+    id = "web-" + Math.random().toString(16).slice(2, 10);
+    localStorage.setItem(KEY, id);
+  }
+  return id;
+}
+
+function newIdempotencyKey(){
+  return Date.now().toString(16) + "-" + Math.random().toString(16).slice(2, 10);
+}
+
+// 带 Idempotency-Key 的 POST：网络错误（超时/断连）时用同一个 key 重试一次，center 会回放第一次的结果
+async function postIdempotent(url, body, key){
+  const init = {
+    method:"POST",
+    headers:{"Content-Type":"application/json", "Idempotency-Key": key},
+    body: JSON.stringify(body),
+  };
+  try{
+    return await fetch(url, init);
+  }catch(e){
+    return fetch(url, init);
+  }
+}
+
 // allocate：携带 CostPref/DelayPref，影响 center 打分权重
 async function apiCpsAllocate(serviceId, measurements, costPref, delayPref){
-  const r = await fetch(`${CENTER_BASE}/api/cps/allocate`,{
-    method:"POST",
-    headers:{"Content-Type":"application/json"},
-    body: JSON.stringify({
-      ServiceID: serviceId,
-      measurements,
-      CostPref: costPref,
-      DelayPref: delayPref,
-    }),
-  });
+  const r = await postIdempotent(`${CENTER_BASE}/api/cps/allocate`, {
+    ServiceID: serviceId,
+    measurements,
+    CostPref: costPref,
+    DelayPref: delayPref,
+    ClientID: getClientId(),
+  }, newIdempotencyKey());
   if(!r.ok) throw new Error(await r.text());
   return r.json();
 }
 
 async function apiRelease(allocationId){
-  const r = await fetch(`${CENTER_BASE}/api/allocations/release`,{
+  // 后端字段名是 allocationId（json tag），这里必须一致
+  const r = await postIdempotent(`${CENTER_BASE}/api/allocations/release`, {allocationId}, `release-${allocationId}`);
+  if(!r.ok) throw new Error(await r.text());
+  return r.json();
+}
+
+// 续租：持有 allocation 期间需周期调用，否则 center 会回收 slot
+async function apiRenew(allocationId){
+  const r = await fetch(`${CENTER_BASE}/api/allocations/${encodeURIComponent(allocationId)}/renew`,{
     method:"POST",
-    headers:{"Content-Type":"application/json"},
-    // 后端字段名是 allocationId（json tag），这里必须一致
-    body: JSON.stringify({allocationId}),
   });
   if(!r.ok) throw new Error(await r.text());
   return r.json();
@@ -1,4 +1,7 @@
-// nma.js — measure RTT by calling /ping (empty body). DO NOT resp.json().
+// nma.js — measure RTT by calling /ping.
+// Gateways without PING_SECRET return an empty body; signing sites return {InstanceID, TS, Token}.
+// With a token we ping a second time with ?echo=<token> so the site signs the RTT it observed,
+// and the center can verify the delay we report (pingToken in the measurement).
 
 function joinPath(a, b) {
   if (!a.endsWith("/")) a += "/";
@@ -18,8 +21,10 @@ function buildPingUrl(addr) {
   return joinPath(addr, "ping");
 }
 
-async function pingOnce(addr, timeoutMs = 2000) {
-  const url = buildPingUrl(addr);
+// returns {delayMs, token}; token is "" when the site does not sign
+async function pingSigned(addr, echo = "", timeoutMs = 2000) {
+  let url = buildPingUrl(addr);
+  if (echo) url += "?echo=" + encodeURIComponent(echo);
   const t0 = performance.now();
 
   const ctrl = new AbortController();
@@ -34,13 +39,30 @@ async function pingOnce(addr, timeoutMs = 2000) {
 
     if (!resp.ok) throw new Error(`ping ${url} -> HTTP ${resp.status}`);
 
+    // body may be empty (unsigned gateway); don't fail on it
+    const text = await resp.text();
     const t1 = performance.now();
-    return Math.max(0, Math.round(t1 - t0));
+    let token = "";
+    try { token = (JSON.parse(text) || {}).Token || ""; } catch (e) { /* empty body */ }
+
+    return { delayMs: Math.max(0, Math.round(t1 - t0)), token };
   } finally {
     clearTimeout(timer);
   }
 }
 
+async function pingOnce(addr, timeoutMs = 2000) {
+  return (await pingSigned(addr, "", timeoutMs)).delayMs;
+}
+
+// measure one instance: second ping echoes the first token so the site signs its observed RTT
+async function measureOne(addr) {
+  const first = await pingSigned(addr);
+  if (!first.token) return { delayMs: first.delayMs, pingToken: "" };
+  const second = await pingSigned(addr, first.token);
+  return { delayMs: second.delayMs, pingToken: second.token };
+}
+
 // cands: [{SiteName, instances:[{instanceId, addr}, ...]}]
 async function measureDelays(cands) {
   const out = [];
@@ -55,13 +77,14 @@ async function measureDelays(cands) {
       const instanceId = inst.instanceId || inst.InstanceID || inst.InstanceId;
       const addr = inst.addr || inst.Addr;
 
-      const delayMs = await pingOnce(addr);
+      const { delayMs, pingToken } = await measureOne(addr);
 
       out.push({
         SiteName,          // ← 修正：大写，和后端 struct 完全一致
         instanceId,
         addr,
         delayMs,
+        pingToken,
       });
     }
   }
@@ -71,4 +94,5 @@ async function measureDelays(cands) {
 
 // expose
 window.pingOnce = pingOnce;
+window.measureOne = measureOne;
 window.measureDelays = measureDelays;
@@ -135,6 +135,27 @@ function initSelection(){
 // --- invocation page ---
 let currentAllocationId = null;
 let currentChosenAddr = null;
+let renewTimer = null;
+
+// 租约心跳：按 expiresAt 剩余时间的一半续租
+function startRenew(alloc){
+  stopRenew();
+  const ttlMs = alloc.expiresAt ? (new Date(alloc.expiresAt) - Date.now()) : 0;
+  const everyMs = Math.max(1000, Math.floor(ttlMs / 2) || 30000);
+  renewTimer = setInterval(async ()=>{
+    if(!currentAllocationId) return stopRenew();
+    try{
+      await apiRenew(currentAllocationId);
+    }catch(e){
+      setErr(`renew failed: ${e}`);
+      stopRenew();
+    }
+  }, everyMs);
+}
+function stopRenew(){
+  if(renewTimer) clearInterval(renewTimer);
+  renewTimer = null;
+}
 
 async function initInvocation(){
   const svcIdEl = $("svcId");
@@ -185,6 +206,7 @@ async function initInvocation(){
 
       currentAllocationId = alloc.allocationId;
       currentChosenAddr = alloc.addr;
+      startRenew(alloc);
 
       if ($("btnEnd")) $("btnEnd").disabled = false;
 
@@ -207,6 +229,7 @@ async function initInvocation(){
         setErr("no allocation");
         return;
       }
+      stopRenew();
       await apiRelease(currentAllocationId);
       currentAllocationId = null;
       currentChosenAddr = null;
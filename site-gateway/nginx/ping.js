@@ -0,0 +1,51 @@
+// ping.js — 签名 ping token（njs），格式与 site/server/ping.go、center/server/pingtoken.go 一致：
+// base64url(payload) + "." + base64url(HMAC-SHA256(PING_SECRET, payload))
+// payload = {i: instanceId, t: 签发时间 ms, n: nonce, r: 站点观测 RTT ms（-1 = 无）}
+import crypto from 'crypto';
+
+function sign(secret, payload) {
+  const body = Buffer.from(JSON.stringify(payload)).toString('base64url');
+  const sig = crypto.createHmac('sha256', secret).update(body).digest('base64url');
+  return body + '.' + sig;
+}
+
+function verify(secret, token) {
+  const parts = String(token).split('.');
+  if (parts.length !== 2) return null;
+  const sig = crypto.createHmac('sha256', secret).update(parts[0]).digest('base64url');
+  if (sig !== parts[1]) return null;
+  try {
+    return JSON.parse(Buffer.from(parts[0], 'base64url').toString());
+  } catch (e) {
+    return null;
+  }
+}
+
+function ping(r) {
+  const secret = r.variables.ping_secret;
+  const instanceId = r.variables.instance_id;
+  r.headersOut['Access-Control-Allow-Origin'] = '*';
+
+  // 没配 PING_SECRET：保持原来的空 200
+  if (!secret) {
+    r.return(200);
+    return;
+  }
+
+  const now = Date.now();
+  let rtt = -1;
+  if (r.args.echo) {
+    const prev = verify(secret, r.args.echo);
+    if (!prev || prev.i !== instanceId) {
+      r.return(400, 'bad echo token');
+      return;
+    }
+    rtt = now - prev.t;
+  }
+
+  const token = sign(secret, { i: instanceId, t: now, n: r.variables.request_id, r: rtt });
+  r.headersOut['Content-Type'] = 'application/json';
+  r.return(200, JSON.stringify({ InstanceID: instanceId, TS: now, Token: token }));
+}
+
+export default { ping };
@@ -27,11 +27,21 @@ func main() {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]any{
+		resp := map[string]any{
 			"InstanceID": instanceID,
 			"TS":         time.Now().UnixMilli(),
-		})
+		}
+		// 配了 PING_SECRET 才签发 token（center 据此校验 client 上报的 delay）
+		if len(pingSecret) > 0 {
+			token, err := issuePing(instanceID, r.URL.Query().Get("echo"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			resp["Token"] = token
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
 	})
 
 	// 供 client 调用
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// 签名 ping token：base64url(payload) + "." + base64url(HMAC-SHA256(PING_SECRET, payload))
+// client 第一次 /ping 拿到 token，第二次 /ping?echo=<token> 带回，站点算出自己观测到的 RTT 并签进新 token；
+// center 用同一个 PING_SECRET 校验（见 center/server/pingtoken.go）。没配 PING_SECRET 时不签名。
+
+var pingSecret = []byte(os.Getenv("PING_SECRET"))
+
+type pingPayload struct {
+	InstanceID string `json:"i"`
+	IssuedAtMs int64  `json:"t"`
+	Nonce      string `json:"n"`
+	RTTMs      int64  `json:"r"` // 站点观测到的 RTT，-1 = 第一次 ping 没有
+}
+
+func signPing(p pingPayload) string {
+	raw, _ := json.Marshal(p)
+	body := base64.RawURLEncoding.EncodeToString(raw)
+	mac := hmac.New(sha256.New, pingSecret)
+	mac.Write([]byte(body))
+	return body + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func verifyPing(token string) (pingPayload, error) {
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return pingPayload{}, errors.New("malformed token")
+	}
+	want, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return pingPayload{}, errors.New("malformed token")
+	}
+	mac := hmac.New(sha256.New, pingSecret)
+	mac.Write([]byte(body))
+	if !hmac.Equal(mac.Sum(nil), want) {
+		return pingPayload{}, errors.New("bad signature")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return pingPayload{}, errors.New("malformed token")
+	}
+	var p pingPayload
+	err = json.Unmarshal(raw, &p)
+	return p, err
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// issuePing 生成本次 /ping 的 token；echo 是上一次签发的 token（可为空）
+func issuePing(instanceID, echo string) (string, error) {
+	now := time.Now()
+	p := pingPayload{InstanceID: instanceID, IssuedAtMs: now.UnixMilli(), Nonce: newNonce(), RTTMs: -1}
+	if echo != "" {
+		prev, err := verifyPing(echo)
+		if err != nil {
+			return "", err
+		}
+		if prev.InstanceID != instanceID {
+			return "", errors.New("token issued by another instance")
+		}
+		p.RTTMs = now.UnixMilli() - prev.IssuedAtMs
+	}
+	return signPing(p), nil
+}